@@ -0,0 +1,68 @@
+package starlarkassert
+
+import (
+	"strings"
+
+	"go.starlark.net/syntax"
+)
+
+// Report summarises the methods each test function calls on its test value.
+type Report struct {
+	Filename string
+	Tests    []TestReport
+}
+
+// TestReport counts the method calls, such as t.eq, of a test function.
+type TestReport struct {
+	Name  string
+	Calls map[string]int
+}
+
+// Total returns the number of method calls in the test.
+func (r TestReport) Total() int {
+	var n int
+	for _, count := range r.Calls {
+		n += count
+	}
+	return n
+}
+
+// AnalyzeFile parses the file and reports the methods called on the test
+// argument by each function with the prefix "test_", without executing it.
+// Source may be nil to read from filename.
+func AnalyzeFile(filename string, src interface{}) (Report, error) {
+	f, err := syntax.Parse(filename, src, 0)
+	if err != nil {
+		return Report{}, err
+	}
+
+	report := Report{Filename: filename}
+	for _, stmt := range f.Stmts {
+		def, ok := stmt.(*syntax.DefStmt)
+		if !ok || !strings.HasPrefix(def.Name.Name, "test_") || len(def.Params) == 0 {
+			continue
+		}
+		param, ok := def.Params[0].(*syntax.Ident)
+		if !ok {
+			continue
+		}
+
+		tr := TestReport{Name: def.Name.Name, Calls: make(map[string]int)}
+		for _, stmt := range def.Body {
+			syntax.Walk(stmt, func(n syntax.Node) bool {
+				call, ok := n.(*syntax.CallExpr)
+				if !ok {
+					return true
+				}
+				if dot, ok := call.Fn.(*syntax.DotExpr); ok {
+					if x, ok := dot.X.(*syntax.Ident); ok && x.Name == param.Name {
+						tr.Calls[dot.Name.Name]++
+					}
+				}
+				return true
+			})
+		}
+		report.Tests = append(report.Tests, tr)
+	}
+	return report, nil
+}
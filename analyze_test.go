@@ -0,0 +1,49 @@
+package starlarkassert
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAnalyzeFile(t *testing.T) {
+	src := `
+def helper(t):
+    t.eq(1, 1)
+
+def test_none(t):
+    helper(t)
+
+def test_some(t):
+    t.eq(1, 1)
+    t.eq(2, 2)
+    for x in [1, 2]:
+        t.true(x, "truthy")
+    other.eq(1, 1)
+
+def test_named(assert):
+    assert.fails(lambda: 1 // 0, "division")
+    if assert.contains([1], 1):
+        assert.eq(1, 1)
+`
+	report, err := AnalyzeFile("analyze.star", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Report{
+		Filename: "analyze.star",
+		Tests: []TestReport{
+			{Name: "test_none", Calls: map[string]int{}},
+			{Name: "test_some", Calls: map[string]int{"eq": 2, "true": 1}},
+			{Name: "test_named", Calls: map[string]int{"fails": 1, "contains": 1, "eq": 1}},
+		},
+	}
+	if !reflect.DeepEqual(report, want) {
+		t.Errorf("got %+v, want %+v", report, want)
+	}
+	for i, total := range []int{0, 3, 3} {
+		if got := report.Tests[i].Total(); got != total {
+			t.Errorf("%s: got %d calls, want %d", report.Tests[i].Name, got, total)
+		}
+	}
+}
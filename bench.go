@@ -3,9 +3,13 @@ package starlarkassert
 import (
 	"fmt"
 	"path/filepath"
+	"runtime"
 	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"go.starlark.net/starlark"
 )
@@ -13,16 +17,23 @@ import (
 // Bench is passed to starlark benchmark functions.
 // Interface is based on Go's *testing.B.
 //
-//   def bench_bar(b):
-//      for _ in range(b.n):
-//         ...work...
-//
+//	def bench_bar(b):
+//	   for _ in range(b.n):
+//	      ...work...
 type Bench struct {
 	b *testing.B
+
+	// Timer state for WithBenchJSON.
+	timing  bool
+	started time.Time
+	elapsed time.Duration
+	bytes   int64
 }
 
 func NewBench(b *testing.B) *Bench {
-	return &Bench{b: b}
+	bb := &Bench{b: b}
+	bb.startTimer()
+	return bb
 }
 
 func (*Bench) Freeze()               {}
@@ -51,54 +62,360 @@ var benchAttrs = map[string]benchAttr{
 	"restart": func(b *Bench) starlark.Value { return method{b, "restart", b.restart} },
 	"start":   func(b *Bench) starlark.Value { return method{b, "start", b.start} },
 	"stop":    func(b *Bench) starlark.Value { return method{b, "stop", b.stop} },
-	"n":       func(b *Bench) starlark.Value { return starlark.MakeInt(b.b.N) },
 
-	"error":  func(b *Bench) starlark.Value { return tmethod{b, "error", b.b, terror} },
-	"fail":   func(b *Bench) starlark.Value { return tmethod{b, "fail", b.b, tfail} },
-	"fatal":  func(b *Bench) starlark.Value { return tmethod{b, "fatal", b.b, tfatal} },
-	"freeze": func(b *Bench) starlark.Value { return method{b, "freeze", freeze} },
-	"skip":   func(b *Bench) starlark.Value { return tmethod{b, "skip", b.b, tskip} },
+	"reset_timer": func(b *Bench) starlark.Value { return method{b, "reset_timer", b.restart} },
+	"start_timer": func(b *Bench) starlark.Value { return method{b, "start_timer", b.start} },
+	"stop_timer":  func(b *Bench) starlark.Value { return method{b, "stop_timer", b.stop} },
+	"n":           func(b *Bench) starlark.Value { return starlark.MakeInt(b.b.N) },
+
+	"set_parallelism": func(b *Bench) starlark.Value { return method{b, "set_parallelism", b.setParallelism} },
+	"run_parallel":    func(b *Bench) starlark.Value { return method{b, "run_parallel", b.runParallel} },
+	"sizes":           func(b *Bench) starlark.Value { return method{b, "sizes", b.sizes} },
+	"set_bytes":       func(b *Bench) starlark.Value { return method{b, "set_bytes", b.setBytes} },
+	"throughput":      func(b *Bench) starlark.Value { return method{b, "throughput", b.throughput} },
+	"keep":            func(b *Bench) starlark.Value { return method{b, "keep", keep} },
 
-	"eq":        func(b *Bench) starlark.Value { return tmethod{b, "eq", b.b, teq} },
-	"equal":     func(b *Bench) starlark.Value { return tmethod{b, "eq", b.b, teq} },
-	"ne":        func(b *Bench) starlark.Value { return tmethod{b, "ne", b.b, tne} },
-	"not_equal": func(b *Bench) starlark.Value { return tmethod{b, "ne", b.b, tne} },
-	"true":      func(b *Bench) starlark.Value { return tmethod{b, "true", b.b, ttrue} },
-	"lt":        func(b *Bench) starlark.Value { return tmethod{b, "lt", b.b, tlt} },
-	"less_than": func(b *Bench) starlark.Value { return tmethod{b, "lt", b.b, tlt} },
-	"contains":  func(b *Bench) starlark.Value { return tmethod{b, "contains", b.b, tcontains} },
-	"fails":     func(b *Bench) starlark.Value { return tmethod{b, "fails", b.b, tfails} },
+	"capture":      func(b *Bench) starlark.Value { return method{b, "capture", capture} },
+	"error":        func(b *Bench) starlark.Value { return tmethod{b, "error", b.b, terror} },
+	"fail":         func(b *Bench) starlark.Value { return tmethod{b, "fail", b.b, tfail} },
+	"failed":       func(b *Bench) starlark.Value { return tmethod{b, "failed", b.b, tfailed} },
+	"fatal":        func(b *Bench) starlark.Value { return tmethod{b, "fatal", b.b, tfatal} },
+	"fatalf":       func(b *Bench) starlark.Value { return tmethod{b, "fatalf", b.b, tfatalf} },
+	"freeze":       func(b *Bench) starlark.Value { return method{b, "freeze", freeze} },
+	"require":      func(b *Bench) starlark.Value { return &Require{b} },
+	"log_value":    func(b *Bench) starlark.Value { return method{b, "log_value", logValue} },
+	"skip":         func(b *Bench) starlark.Value { return tmethod{b, "skip", b.b, tskip} },
+	"with_timeout": func(b *Bench) starlark.Value { return tmethod{b, "with_timeout", b.b, twithTimeout} },
+	"sorted_items": func(b *Bench) starlark.Value { return method{b, "sorted_items", sortedItems} },
+	"type_of":      func(b *Bench) starlark.Value { return method{b, "type_of", typeOf} },
+
+	"eq":                 func(b *Bench) starlark.Value { return tmethod{b, "eq", b.b, teq} },
+	"equal":              func(b *Bench) starlark.Value { return tmethod{b, "eq", b.b, teq} },
+	"eq_repr":            func(b *Bench) starlark.Value { return tmethod{b, "eq_repr", b.b, teqRepr} },
+	"eq_ignoring":        func(b *Bench) starlark.Value { return tmethod{b, "eq_ignoring", b.b, teqIgnoring} },
+	"eq_pairs":           func(b *Bench) starlark.Value { return tmethod{b, "eq_pairs", b.b, teqPairs} },
+	"eq_text":            func(b *Bench) starlark.Value { return tmethod{b, "eq_text", b.b, teqText} },
+	"eq_iter":            func(b *Bench) starlark.Value { return tmethod{b, "eq_iter", b.b, teqIter} },
+	"ne":                 func(b *Bench) starlark.Value { return tmethod{b, "ne", b.b, tne} },
+	"not_equal":          func(b *Bench) starlark.Value { return tmethod{b, "ne", b.b, tne} },
+	"is_same":            func(b *Bench) starlark.Value { return tmethod{b, "is_same", b.b, tisSame} },
+	"true":               func(b *Bench) starlark.Value { return tmethod{b, "true", b.b, ttrue} },
+	"is_truthy":          func(b *Bench) starlark.Value { return tmethod{b, "is_truthy", b.b, tisTruthy} },
+	"is_falsy":           func(b *Bench) starlark.Value { return tmethod{b, "is_falsy", b.b, tisFalsy} },
+	"lt":                 func(b *Bench) starlark.Value { return tmethod{b, "lt", b.b, tlt} },
+	"less_than":          func(b *Bench) starlark.Value { return tmethod{b, "lt", b.b, tlt} },
+	"within_range":       func(b *Bench) starlark.Value { return tmethod{b, "within_range", b.b, twithinRange} },
+	"is_sorted":          func(b *Bench) starlark.Value { return tmethod{b, "is_sorted", b.b, tisSorted} },
+	"contains":           func(b *Bench) starlark.Value { return tmethod{b, "contains", b.b, tcontains} },
+	"contains_all":       func(b *Bench) starlark.Value { return tmethod{b, "contains_all", b.b, tcontainsAll} },
+	"contains_any":       func(b *Bench) starlark.Value { return tmethod{b, "contains_any", b.b, tcontainsAny} },
+	"contains_substring": func(b *Bench) starlark.Value { return tmethod{b, "contains_substring", b.b, tcontainsSubstring} },
+	"empty":              func(b *Bench) starlark.Value { return tmethod{b, "empty", b.b, tempty} },
+	"not_empty":          func(b *Bench) starlark.Value { return tmethod{b, "not_empty", b.b, tnotEmpty} },
+	"all_match":          func(b *Bench) starlark.Value { return tmethod{b, "all_match", b.b, tallMatch} },
+	"any_match":          func(b *Bench) starlark.Value { return tmethod{b, "any_match", b.b, tanyMatch} },
+	"fails":              func(b *Bench) starlark.Value { return tmethod{b, "fails", b.b, tfails} },
+	"assert_type_error":  func(b *Bench) starlark.Value { return tmethod{b, "assert_type_error", b.b, tassertTypeError} },
+	"panics_with_type":   func(b *Bench) starlark.Value { return tmethod{b, "panics_with_type", b.b, tpanicsWithType} },
+
+	"approx":          func(b *Bench) starlark.Value { return tmethod{b, "approx", b.b, tapprox} },
+	"approx_eq_list":  func(b *Bench) starlark.Value { return tmethod{b, "approx_eq_list", b.b, tapproxEqList} },
+	"duration_approx": func(b *Bench) starlark.Value { return tmethod{b, "duration_approx", b.b, tdurationApprox} },
+	"bits_eq":         func(b *Bench) starlark.Value { return tmethod{b, "bits_eq", b.b, tbitsEq} },
+	"same_result":     func(b *Bench) starlark.Value { return tmethod{b, "same_result", b.b, tsameResult} },
+	"bytes_eq":        func(b *Bench) starlark.Value { return tmethod{b, "bytes_eq", b.b, tbytesEq} },
+	"bytes_lt":        func(b *Bench) starlark.Value { return tmethod{b, "bytes_lt", b.b, tbytesLt} },
+	"equal_json_file": func(b *Bench) starlark.Value { return tmethod{b, "equal_json_file", b.b, tequalJSONFile} },
 }
 
 func (b *Bench) restart(_ *starlark.Thread, _ starlark.Tuple, _ []starlark.Tuple) (starlark.Value, error) {
 	b.b.ResetTimer()
+	b.resetTimer()
 	return starlark.None, nil
 }
 
 func (b *Bench) start(_ *starlark.Thread, _ starlark.Tuple, _ []starlark.Tuple) (starlark.Value, error) {
 	b.b.StartTimer()
+	b.startTimer()
 	return starlark.None, nil
 }
 
 func (b *Bench) stop(_ *starlark.Thread, _ starlark.Tuple, _ []starlark.Tuple) (starlark.Value, error) {
 	b.b.StopTimer()
+	b.stopTimer()
+	return starlark.None, nil
+}
+
+func (b *Bench) setParallelism(_ *starlark.Thread, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var p int
+	if err := starlark.UnpackArgs("set_parallelism", args, kwargs, "p", &p); err != nil {
+		return nil, err
+	}
+	b.b.SetParallelism(p)
+	return starlark.None, nil
+}
+
+// keepSink observes the values passed to b.keep.
+var keepSink atomic.Value
+
+// keepBox wraps kept values as atomic.Value requires a consistent type.
+type keepBox struct{ v starlark.Value }
+
+// keep stores x so the result of the benchmarked work is observed. Starlark
+// doesn't eliminate dead code but Go builtins may skip work whose result is
+// unused.
+func keep(_ *starlark.Thread, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var x starlark.Value
+	if err := starlark.UnpackArgs("keep", args, kwargs, "x", &x); err != nil {
+		return nil, err
+	}
+	keepSink.Store(keepBox{x})
+	return starlark.None, nil
+}
+
+func (b *Bench) setBytes(_ *starlark.Thread, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var n int64
+	if err := starlark.UnpackArgs("set_bytes", args, kwargs, "n", &n); err != nil {
+		return nil, err
+	}
+	b.b.SetBytes(n)
+	b.bytes = n
+	return starlark.None, nil
+}
+
+// throughput calls fn b.n times, timing only the calls. Each call is one
+// iteration processing total_bytes, reported as MB/s.
+func (b *Bench) throughput(thread *starlark.Thread, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		n  int64
+		fn starlark.Callable
+	)
+	if err := starlark.UnpackArgs("throughput", args, kwargs, "total_bytes", &n, "fn", &fn); err != nil {
+		return nil, err
+	}
+
+	b.b.SetBytes(n)
+	b.bytes = n
+	b.b.ResetTimer()
+	b.resetTimer()
+	for i := 0; i < b.b.N; i++ {
+		if _, err := starlark.Call(thread, fn, nil, nil); err != nil {
+			return nil, err
+		}
+	}
+	b.b.StopTimer()
+	b.stopTimer()
+	return starlark.None, nil
+}
+
+// sizes runs fn as a sub-benchmark named "size=N" for each size N, called with
+// the sub-benchmark and the size.
+func (b *Bench) sizes(thread *starlark.Thread, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		sizes *starlark.List
+		fn    starlark.Callable
+	)
+	if err := starlark.UnpackArgs("sizes", args, kwargs, "sizes", &sizes, "fn", &fn); err != nil {
+		return nil, err
+	}
+
+	ns := make([]int, sizes.Len())
+	for i := range ns {
+		if err := starlark.AsInt(sizes.Index(i), &ns[i]); err != nil {
+			return nil, fmt.Errorf("sizes: for parameter sizes: index %d: %v", i, err)
+		}
+	}
+
+	for _, n := range ns {
+		n := n
+		b.b.Run(fmt.Sprintf("size=%d", n), func(b *testing.B) {
+			defer wrapLog(b, thread)()
+
+			bb := NewBench(b)
+			if _, err := starlark.Call(
+				thread, fn, starlark.Tuple{bb, starlark.MakeInt(n)}, nil,
+			); err != nil {
+				errorf(b, thread.Name, err)
+			}
+		})
+	}
+	return starlark.None, nil
+}
+
+// runParallel calls fn on each goroutine with a new thread sharing the print
+// and load functions of the calling thread. With setup, setup is first called
+// once on each goroutine and its result passed to fn after the BenchPB, so
+// that each goroutine may have its own state. A goroutine stopped by b.fatal or
+// b.skip stops the benchmark once the others are done.
+func (b *Bench) runParallel(thread *starlark.Thread, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var fn, setup starlark.Callable
+	if err := starlark.UnpackArgs("run_parallel", args, kwargs, "fn", &fn, "setup?", &setup); err != nil {
+		return nil, err
+	}
+
+	var (
+		mu      sync.Mutex
+		ferr    error
+		workers benchWorkers
+	)
+	b.b.RunParallel(func(pb *testing.PB) {
+		// Drain the iterations of a worker stopped by FailNow or SkipNow,
+		// as RunParallel fails bodies returning early.
+		defer func() {
+			for pb.Next() {
+			}
+		}()
+		thread := childThread(thread)
+		thread.SetLocal(benchWorkersKey, &workers)
+		err := func() error {
+			args := starlark.Tuple{&BenchPB{pb: pb}}
+			if setup != nil {
+				state, err := starlark.Call(thread, setup, nil, nil)
+				if err != nil {
+					return err
+				}
+				args = append(args, state)
+			}
+			_, err := starlark.Call(thread, fn, args, nil)
+			return err
+		}()
+		if err != nil {
+			mu.Lock()
+			if ferr == nil {
+				ferr = err
+			}
+			mu.Unlock()
+		}
+	})
+	if workers.failed {
+		b.b.FailNow()
+	}
+	if workers.skipped {
+		b.b.SkipNow()
+	}
+	if ferr != nil {
+		return nil, ferr
+	}
 	return starlark.None, nil
 }
 
-// BenchFile runs each function with the prefix "bench_" as a b.Run func.
+// benchWorkersKey is the thread local of the workers of b.run_parallel.
+const benchWorkersKey = "starlarkassert.benchworkers"
+
+// benchWorkers records the workers of b.run_parallel stopped by FailNow or
+// SkipNow, which testing only allows on the benchmark goroutine.
+type benchWorkers struct {
+	mu              sync.Mutex
+	failed, skipped bool
+}
+
+// newWorkerTB wraps tb, inside any requireTB so that its failures also stop
+// only the worker.
+func newWorkerTB(tb testing.TB, workers *benchWorkers) testing.TB {
+	if r, ok := tb.(requireTB); ok {
+		return requireTB{newWorkerTB(r.TB, workers)}
+	}
+	return &workerTB{TB: tb, workers: workers}
+}
+
+// workerTB stops only the worker's goroutine on FailNow or SkipNow, leaving
+// b.run_parallel to stop the benchmark once the workers are done.
+type workerTB struct {
+	testing.TB
+	workers *benchWorkers
+}
+
+func (w *workerTB) FailNow() {
+	w.TB.Fail()
+	w.workers.mu.Lock()
+	w.workers.failed = true
+	w.workers.mu.Unlock()
+	runtime.Goexit()
+}
+func (w *workerTB) SkipNow() {
+	w.workers.mu.Lock()
+	w.workers.skipped = true
+	w.workers.mu.Unlock()
+	runtime.Goexit()
+}
+func (w *workerTB) Fatal(args ...interface{}) { w.TB.Log(args...); w.FailNow() }
+func (w *workerTB) Fatalf(format string, args ...interface{}) {
+	w.TB.Logf(format, args...)
+	w.FailNow()
+}
+func (w *workerTB) Skip(args ...interface{}) { w.TB.Log(args...); w.SkipNow() }
+func (w *workerTB) Skipf(format string, args ...interface{}) {
+	w.TB.Logf(format, args...)
+	w.SkipNow()
+}
+
+// BenchPB is passed to the function of b.run_parallel.
+// Iterating it is equivalent to calling Next on Go's *testing.PB.
+//
+//	def bench_bar(b):
+//	    def body(pb):
+//	        for _ in pb:
+//	            ...work...
+//	    b.run_parallel(body)
+type BenchPB struct {
+	pb *testing.PB
+}
+
+func (*BenchPB) Freeze()                      {}
+func (*BenchPB) Truth() starlark.Bool         { return true }
+func (*BenchPB) Type() string                 { return "benchmark_pb" }
+func (*BenchPB) String() string               { return "<benchmark_pb>" }
+func (*BenchPB) Hash() (uint32, error)        { return 0, fmt.Errorf("unhashable: benchmark_pb") }
+func (p *BenchPB) Iterate() starlark.Iterator { return benchPBIterator{p.pb} }
+
+// benchPBIterator iterates while there are benchmark iterations remaining.
+type benchPBIterator struct {
+	pb *testing.PB
+}
+
+func (it benchPBIterator) Next(p *starlark.Value) bool {
+	if !it.pb.Next() {
+		return false
+	}
+	*p = starlark.None
+	return true
+}
+func (benchPBIterator) Done() {}
+
+// BenchFile runs each function with the prefix "bench_" as a b.Run func, in
+// name order. Files are skipped by their directives as with TestFile.
 func BenchFile(b *testing.B, filename string, src interface{}, globals starlark.StringDict, opts ...TestOption) {
 	b.Helper()
 
 	thread, cleanup := newThread(b, filename, opts)
 	b.Cleanup(cleanup)
+	if err := preExecErr(thread); err != nil {
+		errorf(b, filename, err)
+		return
+	}
 
-	values, err := starlark.ExecFile(thread, filename, src, globals)
+	data, err := readSource(filename, src)
 	if err != nil {
 		errorf(b, filename, err)
 		return
 	}
+	if reason := skipReason(thread, data); reason != "" {
+		b.Run(strings.Join(nameSegments(filename), "/"), func(b *testing.B) {
+			b.Skipf("%s: skipped: %s", filename, reason)
+		})
+		return
+	}
+	values, err := starlark.ExecFile(thread, filename, data, interceptGlobals(thread, globals))
+	if err != nil {
+		errorf(b, filename, err)
+		return
+	}
+	if err := reportUnused(thread, filename, data); err != nil {
+		errorf(b, filename, err)
+	}
 
-	for key, val := range values {
+	for _, key := range values.Keys() {
+		val := values[key]
 		if !strings.HasPrefix(key, "bench_") {
 			continue // ignore
 		}
@@ -106,13 +423,26 @@ func BenchFile(b *testing.B, filename string, src interface{}, globals starlark.
 			continue // ignore non callable
 		}
 
-		key, val := key, val
+		key := key
+		var result *benchResult
 		b.Run(key, func(b *testing.B) {
 
 			bb := NewBench(b)
+			defer func() {
+				if !b.Failed() && !b.Skipped() {
+					result = bb.result()
+					if result.Name == "" {
+						result.Name = key // unnamed by testing.Benchmark
+					}
+				}
+			}()
 			name := thread.Name
 			thread, cleanup := newThread(b, name, opts)
 			defer cleanup()
+			if err := preExecErr(thread); err != nil {
+				errorf(b, name, err)
+				return
+			}
 
 			if _, err := starlark.Call(
 				thread, val, starlark.Tuple{bb}, nil,
@@ -120,18 +450,19 @@ func BenchFile(b *testing.B, filename string, src interface{}, globals starlark.
 				errorf(b, name, err)
 			}
 		})
+		if j, ok := thread.Local(benchJSONKey).(*benchJSON); ok && result != nil {
+			j.write(result)
+		}
 	}
-
 }
 
 // RunBenches is a local bench suite runner. Each file in the pattern glob is ran.
 // To use add it to a Benchmark function:
 //
-// 	func BenchmarkStarlark(b *testing.B) {
-// 		globals := starlark.StringDict{}
-// 		RunBenches(b, "testdata/*.star", globals)
-// 	}
-//
+//	func BenchmarkStarlark(b *testing.B) {
+//		globals := starlark.StringDict{}
+//		RunBenches(b, "testdata/*.star", globals)
+//	}
 func RunBenches(b *testing.B, pattern string, globals starlark.StringDict, opts ...TestOption) {
 	b.Helper()
 
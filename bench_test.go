@@ -1,6 +1,13 @@
 package starlarkassert
 
 import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"reflect"
+	"runtime"
+	"sync/atomic"
 	"testing"
 
 	"go.starlark.net/starlark"
@@ -13,3 +20,385 @@ func BenchmarkRunBenches(b *testing.B) {
 	}
 	RunBenches(b, "testdata/bench.star", globals)
 }
+
+// benchmark runs f with a fixed number of iterations.
+func benchmark(t *testing.T, f func(b *testing.B)) testing.BenchmarkResult {
+	t.Helper()
+
+	benchtime := flag.Lookup("test.benchtime").Value.String()
+	if err := flag.Set("test.benchtime", "10x"); err != nil {
+		t.Fatal(err)
+	}
+	defer flag.Set("test.benchtime", benchtime)
+	return testing.Benchmark(f)
+}
+
+func TestBenchRunParallel(t *testing.T) {
+	var calls, iterations int64
+	globals := starlark.StringDict{
+		"record": starlark.NewBuiltin("record", func(_ *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			if len(args) > 0 {
+				atomic.AddInt64(&iterations, 1)
+			} else {
+				atomic.AddInt64(&calls, 1)
+			}
+			return starlark.None, nil
+		}),
+	}
+	src := `
+def bench_parallel(b):
+    b.set_parallelism(3)
+
+    def body(pb):
+        record()
+        for _ in pb:
+            record(1)
+
+    b.run_parallel(body)
+`
+	benchmark(t, func(b *testing.B) {
+		BenchFile(b, "parallel.star", src, globals)
+	})
+
+	procs := int64(3 * runtime.GOMAXPROCS(0))
+	if calls == 0 || calls%procs != 0 {
+		t.Errorf("got %d goroutine calls, want a multiple of %d", calls, procs)
+	}
+	if iterations == 0 {
+		t.Error("got no iterations")
+	}
+}
+
+func TestBenchSizes(t *testing.T) {
+	var (
+		sizes   []string
+		benches = make(map[*testing.B]bool)
+	)
+	globals := starlark.StringDict{
+		"record": starlark.NewBuiltin("record", func(_ *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			b := args[0].(*Bench)
+			if !benches[b.b] {
+				benches[b.b] = true
+				sizes = append(sizes, args[1].String())
+			}
+			return starlark.None, nil
+		}),
+	}
+	src := `
+def bench_sizes(b):
+    def bench(b, size):
+        record(b, size)
+        for _ in range(b.n):
+            list(range(size))
+
+    b.sizes([1, 10, 100], bench)
+`
+	benchmark(t, func(b *testing.B) {
+		BenchFile(b, "sizes.star", src, globals)
+	})
+
+	// Each size runs as its own sub-benchmark.
+	if want := []string{"1", "10", "100"}; !reflect.DeepEqual(sizes, want) {
+		t.Errorf("got sizes %q, want %q", sizes, want)
+	}
+}
+
+func TestBenchThroughput(t *testing.T) {
+	var calls int
+	globals := starlark.StringDict{
+		"record": starlark.NewBuiltin("record", func(_ *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			calls++
+			return starlark.None, nil
+		}),
+	}
+	src := `
+def bench_throughput(b):
+    b.throughput(1024, record)
+`
+	r := benchmark(t, func(b *testing.B) {
+		BenchFile(b, "throughput.star", src, globals)
+	})
+	if r.Bytes != 1024 {
+		t.Errorf("got %d bytes, want 1024", r.Bytes)
+	}
+	if calls < 10 {
+		t.Errorf("got %d calls, want at least 10", calls)
+	}
+}
+
+func TestBenchKeep(t *testing.T) {
+	src := `
+def bench_keep(b):
+    for i in range(b.n):
+        b.keep(i)
+`
+	benchmark(t, func(b *testing.B) {
+		BenchFile(b, "keep.star", src, nil)
+	})
+	if got, want := keepSink.Load().(keepBox).v, starlark.MakeInt(9); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestBenchSkip(t *testing.T) {
+	for _, tt := range []struct {
+		src  string
+		want string
+	}{
+		{`b.skip()`, ""},
+		{`b.skip("slow")`, "slow"},
+	} {
+		r := &recorder{TB: t}
+		bb := NewBench(nil)
+		m := benchAttrs["skip"](bb).(tmethod)
+		m.tb = r
+		thread := &starlark.Thread{Name: "skip.star"}
+		var err error
+		r.run(func() {
+			_, err = starlark.ExecFile(thread, thread.Name, tt.src+`; fail("unreachable")`, starlark.StringDict{
+				"b": starlarkstruct.FromStringDict(starlark.String("b"), starlark.StringDict{"skip": m}),
+			})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !r.skipped || r.failed {
+			t.Errorf("%s: got skipped %v, failed %v, want skipped", tt.src, r.skipped, r.failed)
+		}
+		if got := r.output(); got != tt.want {
+			t.Errorf("%s: got output %q, want %q", tt.src, got, tt.want)
+		}
+	}
+
+	benchmark(t, func(b *testing.B) {
+		BenchFile(b, "skip.star", "def bench_skip(b):\n    b.skip()\n", nil)
+	})
+}
+
+func TestWithBenchJSON(t *testing.T) {
+	src := `
+def bench_bytes(b):
+    b.set_bytes(64)
+    for _ in range(b.n):
+        list(range(64))
+
+def bench_skip(b):
+    b.skip()
+`
+	var buf bytes.Buffer
+	benchmark(t, func(b *testing.B) {
+		BenchFile(b, "json.star", src, nil, WithBenchJSON(&buf))
+	})
+
+	var results []map[string]interface{}
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var r map[string]interface{}
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, r)
+	}
+	if len(results) != 1 {
+		t.Fatalf("got %d results, want 1: %v", len(results), results)
+	}
+	r := results[0]
+	if name, _ := r["name"].(string); name != "bench_bytes" {
+		t.Errorf("got name %q, want bench_bytes", name)
+	}
+	if n, _ := r["n"].(float64); n != 10 {
+		t.Errorf("got n %v, want 10", r["n"])
+	}
+	if ns, _ := r["ns_per_op"].(float64); ns <= 0 {
+		t.Errorf("got ns_per_op %v, want > 0", r["ns_per_op"])
+	}
+	metrics, _ := r["metrics"].(map[string]interface{})
+	if mbs, _ := metrics["MB/s"].(float64); mbs <= 0 {
+		t.Errorf("got metrics %v, want MB/s > 0", r["metrics"])
+	}
+}
+
+func TestBenchFileOrder(t *testing.T) {
+	src := `
+def bench_c(b):
+    pass
+
+def bench_a(b):
+    pass
+
+def bench_b(b):
+    pass
+`
+	var buf bytes.Buffer
+	benchmark(t, func(b *testing.B) {
+		BenchFile(b, "order.star", src, nil, WithBenchJSON(&buf))
+		BenchFile(b, "skip.star", "# +starlark:skip\ndef bench_skip(b):\n    pass\n", nil, WithBenchJSON(&buf))
+	})
+
+	var names []string
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var r struct{ Name string }
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, r.Name)
+	}
+	if want := []string{"bench_a", "bench_b", "bench_c"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got %q, want %q", names, want)
+	}
+}
+
+func TestBenchRunParallelSetup(t *testing.T) {
+	var setups, bodies int64
+	globals := starlark.StringDict{
+		"record": starlark.NewBuiltin("record", func(_ *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			if len(args) > 0 {
+				atomic.AddInt64(&bodies, 1)
+			} else {
+				atomic.AddInt64(&setups, 1)
+			}
+			return starlark.None, nil
+		}),
+	}
+	src := `
+def bench_parallel_setup(b):
+    b.set_parallelism(2)
+
+    def setup():
+        record()
+        return []
+
+    def body(pb, buf):
+        if buf:
+            fail("state shared between goroutines")
+        buf.append(1)
+        record(buf)
+        for _ in pb:
+            pass
+
+    b.run_parallel(body, setup = setup)
+`
+	benchmark(t, func(b *testing.B) {
+		BenchFile(b, "parallel_setup.star", src, globals)
+	})
+
+	// Each goroutine runs setup once before its body.
+	if setups == 0 || setups != bodies {
+		t.Errorf("got %d setups for %d bodies, want one each", setups, bodies)
+	}
+	if procs := int64(2 * runtime.GOMAXPROCS(0)); setups%procs != 0 {
+		t.Errorf("got %d setups, want a multiple of %d", setups, procs)
+	}
+}
+
+func TestBenchRunParallelOptions(t *testing.T) {
+	var passed, failed int64
+	globals := starlark.StringDict{
+		"record": starlark.NewBuiltin("record", func(_ *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			if args[0] == starlark.True {
+				atomic.AddInt64(&passed, 1)
+			} else {
+				atomic.AddInt64(&failed, 1)
+			}
+			return starlark.None, nil
+		}),
+	}
+	src := `
+def bench_parallel_options(b):
+    def body(pb):
+        record(b.eq(1.0, 1.0 + 1e-12))
+        for _ in pb:
+            pass
+
+    b.run_parallel(body)
+`
+	benchmark(t, func(b *testing.B) {
+		BenchFile(b, "parallel_options.star", src, globals, WithFloatTolerance(1e-9, 0))
+	})
+	if passed == 0 || failed != 0 {
+		t.Errorf("got %d passed and %d failed, want the tolerance applied in each goroutine", passed, failed)
+	}
+}
+
+func TestBenchRunParallelStop(t *testing.T) {
+	for _, tt := range []struct {
+		name, stop string
+		failed     bool
+	}{
+		{"fatal", `b.fatal("stop")`, true},
+		{"require", `b.require.eq(1, 2)`, true},
+		{"skip", `b.skip("later")`, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var after int64
+			globals := starlark.StringDict{
+				"record": starlark.NewBuiltin("record", func(_ *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+					atomic.AddInt64(&after, 1)
+					return starlark.None, nil
+				}),
+			}
+			src := fmt.Sprintf(`
+def bench_stop(b):
+    def body(pb):
+        %s
+        record()
+
+    b.run_parallel(body)
+    record()
+`, tt.stop)
+			var failed bool
+			benchmark(t, func(b *testing.B) {
+				b.Run("file", func(b *testing.B) {
+					defer func() { failed = b.Failed() }()
+					BenchFile(b, "stop.star", src, globals)
+				})
+			})
+			if after != 0 {
+				t.Errorf("got %d calls after stopping, want 0", after)
+			}
+			if failed != tt.failed {
+				t.Errorf("got failed %v, want %v", failed, tt.failed)
+			}
+		})
+	}
+}
+
+func TestBenchTimerAliases(t *testing.T) {
+	var states []string
+	globals := starlark.StringDict{
+		"record": starlark.NewBuiltin("record", func(_ *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			b := args[0].(*Bench)
+			states = append(states, fmt.Sprintf("timing=%v reset=%v", b.timing, b.elapsed == 0))
+			return starlark.None, nil
+		}),
+	}
+	src := `
+def bench_aliases(b):
+    b.stop_timer()
+    record(b)
+    b.start_timer()
+    record(b)
+    b.reset_timer()
+    record(b)
+`
+	benchmark(t, func(b *testing.B) {
+		BenchFile(b, "aliases.star", src, globals)
+	})
+	want := []string{"timing=false reset=false", "timing=true reset=false", "timing=true reset=true"}
+	if len(states) < 3 || !reflect.DeepEqual(states[len(states)-3:], want) {
+		t.Errorf("got %q, want %q", states, want)
+	}
+
+	for alias, name := range map[string]string{
+		"reset_timer": "restart",
+		"start_timer": "start",
+		"stop_timer":  "stop",
+	} {
+		a := reflect.ValueOf(benchAttrs[alias](&Bench{}).(method).fn).Pointer()
+		n := reflect.ValueOf(benchAttrs[name](&Bench{}).(method).fn).Pointer()
+		if a != n {
+			t.Errorf("%s doesn't call %s", alias, name)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package starlarkassert
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"go.starlark.net/starlark"
+)
+
+// benchJSONKey is the thread local storing the *benchJSON of WithBenchJSON.
+const benchJSONKey = "starlarkassert.benchJSON"
+
+// WithBenchJSON writes the result of each benchmark run by BenchFile to w as a
+// line of JSON, like:
+//
+//	{"name":"BenchmarkStarlark/bench_sum","n":1000,"ns_per_op":1250.5,"metrics":{"MB/s":12.3}}
+//
+// The result is of the final run of the benchmark, as reported by go test.
+func WithBenchJSON(w io.Writer) TestOption {
+	j := &benchJSON{enc: json.NewEncoder(w)}
+	return func(_ testing.TB, thread *starlark.Thread) func() {
+		setLocal(thread, benchJSONKey, j)
+		return nil
+	}
+}
+
+type benchResult struct {
+	Name    string             `json:"name"`
+	N       int                `json:"n"`
+	NsPerOp float64            `json:"ns_per_op"`
+	Metrics map[string]float64 `json:"metrics,omitempty"`
+}
+
+// benchJSON encodes results. Safe for concurrent use.
+type benchJSON struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func (j *benchJSON) write(r *benchResult) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.enc.Encode(r) //nolint:errcheck
+}
+
+// result of the run of bb, timed by the timer methods of b.
+func (b *Bench) result() *benchResult {
+	b.stopTimer()
+	r := &benchResult{Name: b.b.Name(), N: b.b.N}
+	if r.N > 0 {
+		r.NsPerOp = float64(b.elapsed.Nanoseconds()) / float64(r.N)
+	}
+	if b.bytes > 0 && b.elapsed > 0 {
+		r.Metrics = map[string]float64{
+			"MB/s": float64(b.bytes) * float64(r.N) / 1e6 / b.elapsed.Seconds(),
+		}
+	}
+	return r
+}
+
+// startTimer and friends mirror the timer of *testing.B, which isn't
+// readable.
+func (b *Bench) startTimer() {
+	if !b.timing {
+		b.timing = true
+		b.started = time.Now()
+	}
+}
+
+func (b *Bench) stopTimer() {
+	if b.timing {
+		b.timing = false
+		b.elapsed += time.Since(b.started)
+	}
+}
+
+func (b *Bench) resetTimer() {
+	b.elapsed = 0
+	if b.timing {
+		b.started = time.Now()
+	}
+}
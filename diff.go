@@ -1,6 +1,18 @@
 package starlarkassert
 
-import "go.starlark.net/starlark"
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	starlarktime "go.starlark.net/lib/time"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
 
 // A Diffable is a value that can report it's difference.
 type Diffable interface {
@@ -12,3 +24,782 @@ type Diffable interface {
 	// Implementation should be similar to cmp.Diff().
 	DiffSameType(y starlark.Value) (string, error)
 }
+
+// differ accumulates a line per difference found between two values.
+type differ struct {
+	lines []string
+	tol   *tolerance // compares floats within tolerance, if set
+	max   int        // lines reported by String, if positive
+}
+
+// toleranceKey is the thread local storing the *tolerance of
+// WithFloatTolerance.
+const toleranceKey = "starlarkassert.tolerance"
+
+type tolerance struct {
+	rel, abs float64
+}
+
+func threadTolerance(thread *starlark.Thread) *tolerance {
+	tol, _ := thread.Local(toleranceKey).(*tolerance)
+	return tol
+}
+
+// equal reports whether x and y are equal, comparing floats within the
+// tolerance of WithFloatTolerance, if set.
+func equal(thread *starlark.Thread, x, y starlark.Value) (bool, error) {
+	tol := threadTolerance(thread)
+	if tol == nil {
+		return starlark.Equal(x, y)
+	}
+	d := differ{tol: tol}
+	if err := d.diff("", x, y); err != nil {
+		return false, err
+	}
+	return len(d.lines) == 0, nil
+}
+
+// tolerant reports whether the differ compares x and y within a tolerance,
+// as floats or sequences that may contain them.
+func tolerant(x, y starlark.Value) bool {
+	_, xf := x.(starlark.Float)
+	_, yf := y.(starlark.Float)
+	if xf || yf {
+		return true
+	}
+	_, xok := x.(starlark.Indexable)
+	_, yok := y.(starlark.Indexable)
+	_, str := x.(starlark.String)
+	return xok && yok && !str && x.Type() == y.Type()
+}
+
+func (d *differ) addf(path, format string, args ...interface{}) {
+	line := fmt.Sprintf(format, args...)
+	if path != "" {
+		line = path + ": " + line
+	}
+	d.lines = append(d.lines, line)
+}
+
+func (d *differ) String() string {
+	if d.max <= 0 || len(d.lines) <= d.max {
+		return strings.Join(d.lines, "\n")
+	}
+	lines := append(d.lines[:d.max:d.max], fmt.Sprintf("showing %d of %d differences", d.max, len(d.lines)))
+	return strings.Join(lines, "\n")
+}
+
+// diff compares x and y recording each difference under path.
+func (d *differ) diff(path string, x, y starlark.Value) error {
+	if x, ok := x.(*starlark.Dict); ok {
+		if y, ok := y.(*starlark.Dict); ok {
+			return d.diffDict(path, x, y)
+		}
+	}
+	if x, ok := x.(*starlark.Set); ok {
+		if y, ok := y.(*starlark.Set); ok {
+			return d.diffSet(path, x, y)
+		}
+	}
+	if x, ok := asStruct(x); ok {
+		if y, ok := asStruct(y); ok {
+			return d.diffStruct(path, x, y)
+		}
+	}
+	if d.tol != nil {
+		if done, err := d.diffWithin(path, x, y); done || err != nil {
+			return err
+		}
+	}
+	ok, err := starlark.Equal(x, y)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if str, ok := timeDiff(x, y); ok {
+			d.addf(path, "%s", str)
+		} else {
+			d.addf(path, "%s != %s", canonicalString(x), canonicalString(y))
+		}
+	}
+	return nil
+}
+
+// timeDiff reports the difference between two times or durations of the
+// starlark time module as a duration.
+func timeDiff(x, y starlark.Value) (string, bool) {
+	var delta time.Duration
+	switch x := x.(type) {
+	case starlarktime.Time:
+		y, ok := y.(starlarktime.Time)
+		if !ok {
+			return "", false
+		}
+		delta = time.Time(x).Sub(time.Time(y))
+		if delta < 0 {
+			delta = -delta
+		}
+		return fmt.Sprintf("times differ by %s: %s != %s", delta, x, y), true
+	case starlarktime.Duration:
+		y, ok := y.(starlarktime.Duration)
+		if !ok {
+			return "", false
+		}
+		delta = time.Duration(x - y)
+		if delta < 0 {
+			delta = -delta
+		}
+		return fmt.Sprintf("durations differ by %s: %s != %s", delta, x, y), true
+	}
+	return "", false
+}
+
+// diffWithin compares floats within the tolerance, recursing into sequences
+// of equal length to reach them. Reports whether x and y were compared.
+func (d *differ) diffWithin(path string, x, y starlark.Value) (bool, error) {
+	_, xf := x.(starlark.Float)
+	_, yf := y.(starlark.Float)
+	if xf || yf {
+		fx, xok := starlark.AsFloat(x)
+		fy, yok := starlark.AsFloat(y)
+		if !xok || !yok {
+			return false, nil
+		}
+		if !approxEqual(fx, fy, d.tol.rel, d.tol.abs) {
+			d.addf(path, "%s != %s (delta %v)", x, y, math.Abs(fx-fy))
+		}
+		return true, nil
+	}
+
+	xs, xok := x.(starlark.Indexable)
+	ys, yok := y.(starlark.Indexable)
+	if !xok || !yok || x.Type() != y.Type() || xs.Len() != ys.Len() {
+		return false, nil
+	}
+	if _, ok := x.(starlark.String); ok {
+		return false, nil
+	}
+	for i := 0; i < xs.Len(); i++ {
+		if err := d.diff(fmt.Sprintf("%s[%d]", path, i), xs.Index(i), ys.Index(i)); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+// diffDict reports keys present in only one dict before any differing values.
+// Keys of differing types never compare equal, so they are reported as missing.
+// Starlark treats NaN as equal to itself, so NaN keys match each other.
+func (d *differ) diffDict(path string, x, y *starlark.Dict) error {
+	var common []starlark.Tuple
+	for _, item := range x.Items() {
+		yv, found, err := y.Get(item[0])
+		if err != nil {
+			return err
+		}
+		if !found {
+			d.addf(path+"["+item[0].String()+"]", "%smissing from y", presentNone(item[1], "x"))
+			continue
+		}
+		common = append(common, starlark.Tuple{item[0], item[1], yv})
+	}
+	for _, item := range y.Items() {
+		if _, found, err := x.Get(item[0]); err != nil {
+			return err
+		} else if !found {
+			d.addf(path+"["+item[0].String()+"]", "%smissing from x", presentNone(item[1], "y"))
+		}
+	}
+	for _, item := range common {
+		if err := d.diff(path+"["+item[0].String()+"]", item[1], item[2]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// diffSet reports elements present in only one set, ordered by value where
+// the elements are comparable.
+func (d *differ) diffSet(path string, x, y *starlark.Set) error {
+	missing := func(x, y *starlark.Set) ([]starlark.Value, error) {
+		var elems []starlark.Value
+		iter := x.Iterate()
+		defer iter.Done()
+		var p starlark.Value
+		for iter.Next(&p) {
+			if found, err := y.Has(p); err != nil {
+				return nil, err
+			} else if !found {
+				elems = append(elems, p)
+			}
+		}
+		sortValues(elems)
+		return elems, nil
+	}
+
+	onlyX, err := missing(x, y)
+	if err != nil {
+		return err
+	}
+	onlyY, err := missing(y, x)
+	if err != nil {
+		return err
+	}
+	for _, v := range onlyX {
+		d.addf(path, "%s missing from y", v)
+	}
+	for _, v := range onlyY {
+		d.addf(path, "%s missing from x", v)
+	}
+	return nil
+}
+
+// presentNone distinguishes a key mapped to None from an absent key, as both
+// read as None with dict.get.
+func presentNone(v starlark.Value, in string) string {
+	if v == starlark.None {
+		return "present with None in " + in + ", "
+	}
+	return ""
+}
+
+// sortValues sorts the values in place, by their types and representations if
+// any can't be compared, so that the order is deterministic.
+func sortValues(vs []starlark.Value) {
+	sorted := append([]starlark.Value(nil), vs...)
+	var err error
+	sort.SliceStable(sorted, func(i, j int) bool {
+		ok, cerr := starlark.Compare(syntax.LT, sorted[i], sorted[j])
+		if cerr != nil {
+			err = cerr
+		}
+		return ok
+	})
+	if err == nil {
+		copy(vs, sorted)
+		return
+	}
+	type keyed struct {
+		key string
+		v   starlark.Value
+	}
+	ks := make([]keyed, len(vs))
+	for i, v := range vs {
+		ks[i] = keyed{v.Type() + ":" + canonicalString(v), v}
+	}
+	sort.SliceStable(ks, func(i, j int) bool { return ks[i].key < ks[j].key })
+	for i := range ks {
+		vs[i] = ks[i].v
+	}
+}
+
+// diffStruct reports fields present in only one struct before any differing
+// field values.
+func (d *differ) diffStruct(path string, x, y starlark.HasAttrs) error {
+	field := func(name string) string {
+		if path == "" {
+			return name
+		}
+		return path + "." + name
+	}
+
+	xnames, ynames := x.AttrNames(), y.AttrNames()
+	for _, name := range xnames {
+		if !hasString(ynames, name) {
+			d.addf(field(name), "missing from y")
+		}
+	}
+	for _, name := range ynames {
+		if !hasString(xnames, name) {
+			d.addf(field(name), "missing from x")
+		}
+	}
+	for _, name := range xnames {
+		if !hasString(ynames, name) {
+			continue
+		}
+		xv, err := x.Attr(name)
+		if err != nil {
+			return err
+		}
+		yv, err := y.Attr(name)
+		if err != nil {
+			return err
+		}
+		if err := d.diff(field(name), xv, yv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// asStruct matches struct values by type name, including struct types other
+// than starlarkstruct.
+func asStruct(v starlark.Value) (starlark.HasAttrs, bool) {
+	if v.Type() != "struct" {
+		return nil, false
+	}
+	s, ok := v.(starlark.HasAttrs)
+	return s, ok
+}
+
+func hasString(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// hasDiff reports whether x and y are containers diffValues can compare.
+func hasDiff(x, y starlark.Value) bool {
+	if _, ok := x.(*starlark.Dict); ok {
+		_, ok := y.(*starlark.Dict)
+		return ok
+	}
+	if _, ok := x.(*starlark.Set); ok {
+		_, ok := y.(*starlark.Set)
+		return ok
+	}
+	if _, ok := asStruct(x); ok {
+		_, ok := asStruct(y)
+		return ok
+	}
+	return false
+}
+
+// diffValues returns a report of the differences between x and y.
+func diffValues(thread *starlark.Thread, x, y starlark.Value) (string, error) {
+	d := differ{tol: threadTolerance(thread), max: maxDiffs(thread)}
+	if err := d.diff("", x, y); err != nil {
+		return "", err
+	}
+	return d.String(), nil
+}
+
+// diffMessage describes why x and y aren't equal.
+func diffMessage(thread *starlark.Thread, x, y starlark.Value) (str string, err error) {
+	if v, ok := x.(Diffable); ok {
+		str, err = v.DiffSameType(y)
+	} else if hasDiff(x, y) || (threadTolerance(thread) != nil && tolerant(x, y)) {
+		str, err = diffValues(thread, x, y)
+	}
+	if err != nil {
+		return "", err
+	}
+	if str != "" {
+		return str, nil
+	}
+	if str, ok := timeDiff(x, y); ok {
+		return str, nil
+	}
+	if xs, ok := x.(starlark.String); ok {
+		if ys, ok := y.(starlark.String); ok && (strings.Contains(string(xs), "\n") || strings.Contains(string(ys), "\n")) {
+			return lineDiff(string(xs), string(ys), diffContext(thread)), nil
+		}
+	}
+	if reflectDiff, _ := thread.Local(reflectDiffKey).(bool); reflectDiff && (opaque(x) || opaque(y)) {
+		return fmt.Sprintf("%s != %s", reflectString(x), reflectString(y)), nil
+	}
+	if sideBySide(thread) {
+		return sideBySideDiff(x, y), nil
+	}
+	if str, ok := collapsedDiff(x, y, diffContext(thread)); ok {
+		return str, nil
+	}
+	return fmt.Sprintf("%q != %q", canonicalString(x), canonicalString(y)), nil
+}
+
+// collapsedDiff renders lists or tuples with the runs of equal elements they
+// start and end with collapsed, keeping context elements either side of the
+// change. Reports false if there's nothing to collapse.
+func collapsedDiff(x, y starlark.Value, context int) (string, bool) {
+	xs, ok := x.(starlark.Indexable)
+	if !ok || x.Type() != y.Type() || (x.Type() != "list" && x.Type() != "tuple") {
+		return "", false
+	}
+	ys := y.(starlark.Indexable)
+	xn, yn := xs.Len(), ys.Len()
+
+	equal := func(i, j int) bool {
+		ok, err := starlark.Equal(xs.Index(i), ys.Index(j))
+		return err == nil && ok
+	}
+	var prefix int
+	for prefix < xn && prefix < yn && equal(prefix, prefix) {
+		prefix++
+	}
+	var suffix int
+	for suffix < xn-prefix && suffix < yn-prefix && equal(xn-1-suffix, yn-1-suffix) {
+		suffix++
+	}
+	// A single element is shorter than its marker so isn't collapsed.
+	head, tail := prefix-context, suffix-context
+	if head < 2 {
+		head = 0
+	}
+	if tail < 2 {
+		tail = 0
+	}
+	if head == 0 && tail == 0 {
+		return "", false
+	}
+
+	render := func(v starlark.Indexable, n int) string {
+		var elems []string
+		if head > 0 {
+			elems = append(elems, fmt.Sprintf("… (%d unchanged) …", head))
+		}
+		for i := head; i < n-tail; i++ {
+			elems = append(elems, canonicalString(v.Index(i)))
+		}
+		if tail > 0 {
+			elems = append(elems, fmt.Sprintf("… (%d unchanged) …", tail))
+		}
+		if x.Type() == "tuple" {
+			return "(" + strings.Join(elems, ", ") + ")"
+		}
+		return "[" + strings.Join(elems, ", ") + "]"
+	}
+	return render(xs, xn) + " != " + render(ys, yn), true
+}
+
+// sideBySideKey is the thread local set by WithSideBySideDiff.
+const sideBySideKey = "starlarkassert.sidebyside"
+
+func sideBySide(thread *starlark.Thread) bool {
+	ok, _ := thread.Local(sideBySideKey).(bool)
+	return ok
+}
+
+// sideBySideDiff renders x and y in two columns headed by their types.
+func sideBySideDiff(x, y starlark.Value) string {
+	left := append([]string{"got " + x.Type()}, columnRows(x)...)
+	right := append([]string{"want " + y.Type()}, columnRows(y)...)
+
+	var width int
+	for _, row := range left {
+		if n := utf8.RuneCountInString(row); n > width {
+			width = n
+		}
+	}
+	rows := len(left)
+	if len(right) > rows {
+		rows = len(right)
+	}
+	lines := make([]string, rows)
+	for i := range lines {
+		var l, r string
+		if i < len(left) {
+			l = left[i]
+		}
+		if i < len(right) {
+			r = right[i]
+		}
+		if r == "" {
+			lines[i] = l
+			continue
+		}
+		lines[i] = fmt.Sprintf("%-*s | %s", width, l, r)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// columnRows splits the elements of lists and tuples over rows.
+func columnRows(v starlark.Value) []string {
+	var open, close string
+	switch v.(type) {
+	case *starlark.List:
+		open, close = "[", "]"
+	case starlark.Tuple:
+		open, close = "(", ")"
+	default:
+		return []string{canonicalString(v)}
+	}
+	seq := v.(starlark.Indexable)
+	if seq.Len() == 0 {
+		return []string{canonicalString(v)}
+	}
+	rows := []string{open}
+	for i := 0; i < seq.Len(); i++ {
+		rows = append(rows, "  "+canonicalString(seq.Index(i))+",")
+	}
+	return append(rows, close)
+}
+
+// canonicalString returns the Repr of v, so that the strings of equal values
+// match, or its String if v can't be represented.
+func canonicalString(v starlark.Value) string {
+	s, err := Repr(v)
+	if err != nil {
+		return v.String()
+	}
+	return s
+}
+
+// Repr returns a deterministic representation of v. Unlike the value's
+// String, the keys of dicts, elements of sets and fields of structs are
+// sorted, including those nested in other values, so that equal values have
+// equal representations. Keys that can't be compared are sorted by their
+// representation. Lists and dicts that contain themselves are written as [...]
+// and {...}.
+func Repr(v starlark.Value) (string, error) {
+	var b strings.Builder
+	if err := writeCanonical(&b, v, make(map[starlark.Value]bool)); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// writeCanonical writes v to b, tracking the containers being written in
+// path to break cycles.
+func writeCanonical(b *strings.Builder, v starlark.Value, path map[starlark.Value]bool) error {
+	writeElems := func(elems []starlark.Value) error {
+		for i, elem := range elems {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			if err := writeCanonical(b, elem, path); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	switch v := v.(type) {
+	case *starlark.Dict:
+		if path[v] {
+			b.WriteString("{...}")
+			return nil
+		}
+		path[v] = true
+		defer delete(path, v)
+
+		keys := v.Keys()
+		sortValues(keys)
+		b.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			val, _, err := v.Get(k)
+			if err != nil {
+				return err
+			}
+			if err := writeCanonical(b, k, path); err != nil {
+				return err
+			}
+			b.WriteString(": ")
+			if err := writeCanonical(b, val, path); err != nil {
+				return err
+			}
+		}
+		b.WriteByte('}')
+	case *starlark.List:
+		if path[v] {
+			b.WriteString("[...]")
+			return nil
+		}
+		path[v] = true
+		defer delete(path, v)
+
+		elems := make([]starlark.Value, v.Len())
+		for i := range elems {
+			elems[i] = v.Index(i)
+		}
+		b.WriteByte('[')
+		if err := writeElems(elems); err != nil {
+			return err
+		}
+		b.WriteByte(']')
+	case starlark.Tuple:
+		b.WriteByte('(')
+		if err := writeElems(v); err != nil {
+			return err
+		}
+		if len(v) == 1 {
+			b.WriteByte(',')
+		}
+		b.WriteByte(')')
+	case *starlark.Set:
+		var elems []starlark.Value
+		iter := v.Iterate()
+		var p starlark.Value
+		for iter.Next(&p) {
+			elems = append(elems, p)
+		}
+		iter.Done()
+		sortValues(elems)
+		b.WriteString("set([")
+		if err := writeElems(elems); err != nil {
+			return err
+		}
+		b.WriteString("])")
+	default:
+		s, ok := asStruct(v)
+		if !ok {
+			b.WriteString(v.String())
+			return nil
+		}
+		names := s.AttrNames()
+		sort.Strings(names)
+		b.WriteString("struct(")
+		for i, name := range names {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			val, err := s.Attr(name)
+			if err != nil {
+				return err
+			}
+			b.WriteString(name + " = ")
+			if err := writeCanonical(b, val, path); err != nil {
+				return err
+			}
+		}
+		b.WriteByte(')')
+	}
+	return nil
+}
+
+// reflectDiffKey is the thread local set by WithReflectDiff.
+const reflectDiffKey = "starlarkassert.reflectdiff"
+
+// opaque reports whether the value's string is only its type.
+func opaque(v starlark.Value) bool {
+	s := v.String()
+	return s == v.Type() || s == "<"+v.Type()+">"
+}
+
+// reflectString formats the exported fields of the Go value underlying v.
+func reflectString(v starlark.Value) string {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return fmt.Sprintf("%s(%v)", v.Type(), rv)
+	}
+
+	var fields []string
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		if f := rt.Field(i); f.PkgPath == "" {
+			fields = append(fields, fmt.Sprintf("%s: %v", f.Name, rv.Field(i)))
+		}
+	}
+	return fmt.Sprintf("%s{%s}", v.Type(), strings.Join(fields, ", "))
+}
+
+// diffContextKey is the thread local set by WithDiffContext.
+const diffContextKey = "starlarkassert.diffcontext"
+
+// maxDiffsKey is the thread local set by WithMaxDiffs.
+const maxDiffsKey = "starlarkassert.maxdiffs"
+
+func maxDiffs(thread *starlark.Thread) int {
+	n, _ := thread.Local(maxDiffsKey).(int)
+	return n
+}
+
+// diffContext returns the context of WithDiffContext, at least 0.
+func diffContext(thread *starlark.Thread) int {
+	if n, ok := thread.Local(diffContextKey).(int); ok {
+		if n < 0 {
+			return 0
+		}
+		return n
+	}
+	return 3
+}
+
+// maxLineDiffCells limits the size of the table lineDiff builds, the product
+// of the line counts, to 8MB.
+const maxLineDiffCells = 1 << 20
+
+// lineDiff returns a unified diff of the lines of x and y, like diff -U with
+// context lines of unchanged text around each change. Texts too large to
+// diff are quoted in full.
+func lineDiff(x, y string, context int) string {
+	xs, ys := strings.Split(x, "\n"), strings.Split(y, "\n")
+	if (len(xs)+1)*(len(ys)+1) > maxLineDiffCells {
+		return fmt.Sprintf("%q != %q", x, y)
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of xs[i:]
+	// and ys[j:].
+	lcs := make([][]int, len(xs)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(ys)+1)
+	}
+	for i := len(xs) - 1; i >= 0; i-- {
+		for j := len(ys) - 1; j >= 0; j-- {
+			if xs[i] == ys[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	type edit struct {
+		op   byte // ' ', '-' or '+'
+		line string
+		x, y int // line indexes before the edit
+	}
+	var edits []edit
+	i, j := 0, 0
+	for i < len(xs) || j < len(ys) {
+		switch {
+		case i < len(xs) && j < len(ys) && xs[i] == ys[j]:
+			edits = append(edits, edit{' ', xs[i], i, j})
+			i, j = i+1, j+1
+		case j == len(ys) || (i < len(xs) && lcs[i+1][j] >= lcs[i][j+1]):
+			edits = append(edits, edit{'-', xs[i], i, j})
+			i++
+		default:
+			edits = append(edits, edit{'+', ys[j], i, j})
+			j++
+		}
+	}
+
+	var b strings.Builder
+	for k := 0; k < len(edits); {
+		if edits[k].op == ' ' {
+			k++
+			continue
+		}
+		// Extend the hunk while changes are within 2*context lines.
+		start := k - context
+		if start < 0 {
+			start = 0
+		}
+		end := k
+		for n := k; n < len(edits); n++ {
+			if edits[n].op != ' ' {
+				end = n + 1
+			} else if n-end >= 2*context {
+				break
+			}
+		}
+		if end += context; end > len(edits) {
+			end = len(edits)
+		}
+
+		var nx, ny int
+		for _, e := range edits[start:end] {
+			if e.op != '+' {
+				nx++
+			}
+			if e.op != '-' {
+				ny++
+			}
+		}
+		fmt.Fprintf(&b, "@@ -%d,%d +%d,%d @@\n", edits[start].x+1, nx, edits[start].y+1, ny)
+		for _, e := range edits[start:end] {
+			fmt.Fprintf(&b, "%c%s\n", e.op, e.line)
+		}
+		k = end
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
@@ -0,0 +1,87 @@
+package starlarkassert
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"go.starlark.net/starlark"
+)
+
+// tagsKey is the thread local storing the tags of WithTags.
+const tagsKey = "starlarkassert.tags"
+
+// WithTags sets the tags satisfying file directives. A file's leading comment
+// lines may contain directives controlling whether it's run:
+//
+//	# +starlark:skip
+//	# +starlark:requires network
+//
+// skip never runs the file and requires runs it only if each tag named is
+// set. A file not run is reported as a skipped subtest named by the file.
+func WithTags(tags []string) TestOption {
+	set := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		set[tag] = true
+	}
+	return func(_ testing.TB, thread *starlark.Thread) func() {
+		setLocal(thread, tagsKey, set)
+		return nil
+	}
+}
+
+// readSource returns the source of a file as ExecFile would read it.
+func readSource(filename string, src interface{}) ([]byte, error) {
+	switch src := src.(type) {
+	case nil:
+		return os.ReadFile(filename)
+	case string:
+		return []byte(src), nil
+	case []byte:
+		return src, nil
+	case io.Reader:
+		return io.ReadAll(src)
+	default:
+		return nil, fmt.Errorf("invalid source: %T", src)
+	}
+}
+
+// skipReason returns why the directives of src aren't satisfied by the
+// thread's tags, or an empty string if the file should run.
+func skipReason(thread *starlark.Thread, src []byte) string {
+	tags, _ := thread.Local(tagsKey).(map[string]bool)
+
+	sc := bufio.NewScanner(bytes.NewReader(src))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "#") {
+			break // end of leading comments
+		}
+		directive := strings.TrimSpace(strings.TrimPrefix(line, "#"))
+		if !strings.HasPrefix(directive, "+starlark:") {
+			continue
+		}
+		fields := strings.Fields(strings.TrimPrefix(directive, "+starlark:"))
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "skip":
+			return "skip directive"
+		case "requires":
+			for _, tag := range fields[1:] {
+				if !tags[tag] {
+					return "requires " + tag
+				}
+			}
+		}
+	}
+	return ""
+}
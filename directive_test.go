@@ -0,0 +1,56 @@
+package starlarkassert
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestWithTags(t *testing.T) {
+	fsys := fstest.MapFS{
+		"star/network.star": {Data: []byte("# Tests of the network.\n# +starlark:requires network\n\ndef test_network(t):\n    pass\n")},
+		"star/plain.star":   {Data: []byte("def test_plain(t):\n    pass\n")},
+		"star/skip.star":    {Data: []byte("# +starlark:skip\ndef test_skip(t):\n    t.fail()\n")},
+		"star/late.star":    {Data: []byte("def test_late(t):\n    pass\n# +starlark:skip\n")},
+	}
+	run := func(name string, opts ...TestOption) []string {
+		var results []string
+		opts = append(opts, WithResultCallback(func(name string, _ bool, _ time.Duration, msgs []string) {
+			results = append(results, strings.Join(append([]string{name}, msgs...), ": "))
+		}))
+		t.Run(name, func(t *testing.T) {
+			RunTestsFS(t, fsys, "star/*.star", nil, opts...)
+		})
+		return results
+	}
+
+	prefix := t.Name() + "/untagged/"
+	want := []string{
+		prefix + "test_late",
+		prefix + "star/network: star/network.star: skipped: requires network",
+		prefix + "test_plain",
+		prefix + "star/skip: star/skip.star: skipped: skip directive",
+	}
+	if got := run("untagged"); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	prefix = t.Name() + "/network/"
+	want = []string{
+		prefix + "test_late",
+		prefix + "test_network",
+		prefix + "test_plain",
+		prefix + "star/skip: star/skip.star: skipped: skip directive",
+	}
+	if got := run("network", WithTags([]string{"network"})); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// Skipped files are reported as skipped test cases.
+	var buf strings.Builder
+	run("junit", WithJUnitOutput(&buf))
+	if want := `<skipped message="star/skip.star: skipped: skip directive"></skipped>`; !strings.Contains(buf.String(), want) {
+		t.Errorf("got:\n%s\nwant skipped case %s", buf.String(), want)
+	}
+}
@@ -0,0 +1,119 @@
+package starlarkassert
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.starlark.net/starlark"
+)
+
+// exampleOutput returns the expected output of an example from the lines
+// following "Output:" in its docstring.
+func exampleOutput(doc string) (string, bool) {
+	lines := strings.Split(doc, "\n")
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "Output:" || line == "# Output:" {
+			return trimOutput(strings.Join(lines[i+1:], "\n")), true
+		}
+	}
+	return "", false
+}
+
+// trimOutput removes surrounding whitespace from the output and its lines.
+func trimOutput(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// runExample calls fn checking its printed output matches want.
+func runExample(t testing.TB, thread *starlark.Thread, fn starlark.Callable, want string) {
+	t.Helper()
+
+	got, err := capture(thread, starlark.Tuple{fn}, nil)
+	if err != nil {
+		errorf(t, thread.Name, err)
+		return
+	}
+	if got := trimOutput(string(got.(starlark.String))); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// ExampleFile runs each function with the prefix "example_" as a t.Run func,
+// checking its printed output matches the "Output:" block of its docstring.
+// Examples without an output block are not run.
+//
+//	def example_hello():
+//	    """Greets the world.
+//
+//	    Output:
+//	    hello, world
+//	    """
+//	    print("hello, world")
+func ExampleFile(t *testing.T, filename string, src interface{}, globals starlark.StringDict, opts ...TestOption) {
+	t.Helper()
+
+	thread, cleanup := newThread(t, filename, opts)
+	t.Cleanup(cleanup)
+	if err := preExecErr(thread); err != nil {
+		errorf(t, filename, err)
+		return
+	}
+
+	values, err := starlark.ExecFile(thread, filename, src, interceptGlobals(thread, globals))
+	if err != nil {
+		errorf(t, filename, err)
+		return
+	}
+
+	for _, key := range values.Keys() {
+		if !strings.HasPrefix(key, "example_") {
+			continue // ignore
+		}
+		fn, ok := values[key].(*starlark.Function)
+		if !ok {
+			continue // ignore non functions
+		}
+		want, ok := exampleOutput(fn.Doc())
+		if !ok {
+			continue // ignore examples without output
+		}
+
+		t.Run(key, func(t *testing.T) {
+			name := thread.Name
+			thread, cleanup := newThread(t, name, opts)
+			defer cleanup()
+			if err := preExecErr(thread); err != nil {
+				errorf(t, name, err)
+				return
+			}
+
+			runExample(t, thread, fn, want)
+		})
+	}
+}
+
+// RunExamples is a local example suite runner. Each file in the pattern glob
+// is ran with ExampleFile.
+//
+//	func TestStarlarkExamples(t *testing.T) {
+//		globals := starlark.StringDict{}
+//		RunExamples(t, "testdata/*.star", globals)
+//	}
+func RunExamples(t *testing.T, pattern string, globals starlark.StringDict, opts ...TestOption) {
+	t.Helper()
+
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, filename := range files {
+		ExampleFile(t, filename, nil, globals, opts...)
+	}
+}
@@ -0,0 +1,41 @@
+package starlarkassert
+
+import (
+	"testing"
+
+	"go.starlark.net/starlark"
+)
+
+func TestRunExamples(t *testing.T) {
+	RunExamples(t, "testdata/example.star", nil)
+}
+
+func TestExampleFailure(t *testing.T) {
+	src := `
+def example_wrong():
+    """
+    Output:
+    hello
+    """
+    print("goodbye")
+`
+	thread := &starlark.Thread{Name: "example.star"}
+	values, err := starlark.ExecFile(thread, thread.Name, src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn := values["example_wrong"].(*starlark.Function)
+	want, ok := exampleOutput(fn.Doc())
+	if !ok {
+		t.Fatal("missing output")
+	}
+
+	r := &recorder{TB: t}
+	runExample(r, thread, fn, want)
+	if !r.failed {
+		t.Fatal("expected failure")
+	}
+	if got, want := r.output(), "got:\ngoodbye\nwant:\nhello"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,107 @@
+package starlarkassert
+
+import (
+	"strings"
+
+	"go.starlark.net/starlark"
+)
+
+const indent = "    "
+
+// prettyPrinter writes values across multiple indented lines.
+type prettyPrinter struct {
+	buf   strings.Builder
+	stack []starlark.Value // containers being printed, to break cycles
+}
+
+func (p *prettyPrinter) newline(depth int) {
+	p.buf.WriteByte('\n')
+	p.buf.WriteString(strings.Repeat(indent, depth))
+}
+
+func (p *prettyPrinter) seen(v starlark.Value) bool {
+	for _, s := range p.stack {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// elems writes each element produced by write between open and close.
+func (p *prettyPrinter) elems(open, close string, n, depth int, write func(i int)) {
+	p.buf.WriteString(open)
+	if n == 0 {
+		p.buf.WriteString(close)
+		return
+	}
+	for i := 0; i < n; i++ {
+		p.newline(depth + 1)
+		write(i)
+		p.buf.WriteByte(',')
+	}
+	p.newline(depth)
+	p.buf.WriteString(close)
+}
+
+func (p *prettyPrinter) print(v starlark.Value, depth int) {
+	switch v := v.(type) {
+	case *starlark.List:
+		if p.seen(v) {
+			p.buf.WriteString("[...]")
+			return
+		}
+		p.stack = append(p.stack, v)
+		defer func() { p.stack = p.stack[:len(p.stack)-1] }()
+
+		p.elems("[", "]", v.Len(), depth, func(i int) { p.print(v.Index(i), depth+1) })
+	case starlark.Tuple:
+		p.elems("(", ")", v.Len(), depth, func(i int) { p.print(v.Index(i), depth+1) })
+	case *starlark.Dict:
+		if p.seen(v) {
+			p.buf.WriteString("{...}")
+			return
+		}
+		p.stack = append(p.stack, v)
+		defer func() { p.stack = p.stack[:len(p.stack)-1] }()
+
+		items := v.Items()
+		p.elems("{", "}", len(items), depth, func(i int) {
+			p.print(items[i][0], depth+1)
+			p.buf.WriteString(": ")
+			p.print(items[i][1], depth+1)
+		})
+	case *starlark.Set:
+		var elems []starlark.Value
+		iter := v.Iterate()
+		defer iter.Done()
+		var x starlark.Value
+		for iter.Next(&x) {
+			elems = append(elems, x)
+		}
+		p.elems("set([", "])", len(elems), depth, func(i int) { p.print(elems[i], depth+1) })
+	default:
+		s, ok := asStruct(v)
+		if !ok {
+			p.buf.WriteString(v.String())
+			return
+		}
+		names := s.AttrNames()
+		p.elems("struct(", ")", len(names), depth, func(i int) {
+			p.buf.WriteString(names[i])
+			p.buf.WriteString(" = ")
+			if x, err := s.Attr(names[i]); err != nil {
+				p.buf.WriteString(err.Error())
+			} else {
+				p.print(x, depth+1)
+			}
+		})
+	}
+}
+
+// prettyString formats v across multiple indented lines.
+func prettyString(v starlark.Value) string {
+	var p prettyPrinter
+	p.print(v, 0)
+	return p.buf.String()
+}
@@ -0,0 +1,64 @@
+package starlarkassert
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	"go.starlark.net/starlark"
+)
+
+// Fuzz is passed to starlark fuzz functions with each generated input. It has
+// the assertions of Test, reported on the *testing.T of the input as
+// *testing.F can't be used within the fuzz target:
+//
+//	func FuzzReverse(f *testing.F) {
+//		f.Add("abc")
+//		f.Fuzz(func(t *testing.T, s string) {
+//			thread := &starlark.Thread{Name: "reverse.star"}
+//			starlark.Call(thread, fuzzReverse, starlark.Tuple{
+//				starlarkassert.NewFuzz(t), starlark.String(s),
+//			}, nil)
+//		})
+//	}
+//
+//	def fuzz_reverse(t, s):
+//	    t.eq(reverse(reverse(s)), s)
+type Fuzz struct {
+	t *testing.T
+}
+
+func NewFuzz(t *testing.T) *Fuzz {
+	return &Fuzz{t: t}
+}
+
+func (f *Fuzz) String() string        { return "<fuzz>" }
+func (f *Fuzz) Type() string          { return "fuzz" }
+func (f *Fuzz) Freeze()               {}
+func (f *Fuzz) Truth() starlark.Bool  { return f.t != nil }
+func (f *Fuzz) Hash() (uint32, error) { return 0, fmt.Errorf("unhashable type: %s", f.Type()) }
+
+// Attr returns the assertions of Test, those reporting to the test.
+func (f *Fuzz) Attr(name string) (starlark.Value, error) {
+	attr := testAttrs[name]
+	if attr == nil {
+		return nil, nil
+	}
+	m, ok := attr(&Test{t: f.t}).(tmethod)
+	if !ok {
+		return nil, nil
+	}
+	m.recv = f
+	return m, nil
+}
+
+func (f *Fuzz) AttrNames() []string {
+	var names []string
+	for name, attr := range testAttrs {
+		if _, ok := attr(&Test{}).(tmethod); ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
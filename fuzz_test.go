@@ -0,0 +1,56 @@
+package starlarkassert
+
+import (
+	"testing"
+
+	"go.starlark.net/starlark"
+)
+
+func FuzzAssertions(f *testing.F) {
+	src := `
+def reverse(s):
+    return "".join(reversed(s.elems()))
+
+def fuzz_reverse(t, s):
+    t.eq(reverse(reverse(s)), s)
+    t.eq(len(reverse(s)), len(s))
+    t.true(t.failed() == False)
+`
+	thread := &starlark.Thread{Name: "fuzz.star"}
+	globals, err := starlark.ExecFile(thread, thread.Name, src, nil)
+	if err != nil {
+		f.Fatal(err)
+	}
+	fn := globals["fuzz_reverse"]
+
+	f.Add("abc")
+	f.Add("")
+	f.Add("héllo")
+	f.Fuzz(func(t *testing.T, s string) {
+		thread := &starlark.Thread{Name: "fuzz.star"}
+		defer wrapLog(t, thread)()
+		if _, err := starlark.Call(thread, fn, starlark.Tuple{NewFuzz(t), starlark.String(s)}, nil); err != nil {
+			errorf(t, thread.Name, err)
+		}
+	})
+}
+
+func TestFuzz(t *testing.T) {
+	fz := NewFuzz(nil)
+	for _, name := range fz.AttrNames() {
+		switch name {
+		case "run", "require", "capture", "freeze":
+			t.Errorf("got non-assertion %s", name)
+		}
+	}
+	v, _ := fz.Attr("eq")
+	if v == nil {
+		t.Fatal("missing eq")
+	}
+	if got, want := v.String(), "<builtin_method eq of fuzz value>"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if v, _ := fz.Attr("run"); v != nil {
+		t.Errorf("got run %v, want none", v)
+	}
+}
@@ -0,0 +1,58 @@
+package starlarkassert
+
+import (
+	"flag"
+	"sync"
+)
+
+// updateFlagName is the flag regenerating golden files, shared by all golden
+// assertions:
+//
+//	go test -starlarkassert.update
+const updateFlagName = "starlarkassert.update"
+
+func init() {
+	// Another package may have registered the flag first.
+	if flag.Lookup(updateFlagName) == nil {
+		flag.Bool(updateFlagName, false, "update golden files")
+	}
+}
+
+var (
+	updateMu       sync.Mutex
+	updateOverride *bool
+)
+
+// SetUpdateGoldens sets whether golden assertions rewrite their files with
+// the values given rather than comparing them, overriding the
+// -starlarkassert.update flag. The returned func restores the previous
+// setting, so the flag applies again once every override is restored:
+//
+//	defer SetUpdateGoldens(true)()
+func SetUpdateGoldens(update bool) (restore func()) {
+	updateMu.Lock()
+	defer updateMu.Unlock()
+	prev := updateOverride
+	updateOverride = &update
+	return func() {
+		updateMu.Lock()
+		defer updateMu.Unlock()
+		updateOverride = prev
+	}
+}
+
+// updateGoldens reports whether golden files should be rewritten.
+func updateGoldens() bool {
+	updateMu.Lock()
+	defer updateMu.Unlock()
+	if updateOverride != nil {
+		return *updateOverride
+	}
+	if f := flag.Lookup(updateFlagName); f != nil {
+		if g, ok := f.Value.(flag.Getter); ok {
+			update, _ := g.Get().(bool)
+			return update
+		}
+	}
+	return false
+}
@@ -2,11 +2,20 @@ package starlarkassert
 
 import (
 	_ "embed"
+	"errors"
 	"fmt"
+	"math"
+	"reflect"
 	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	starlarktime "go.starlark.net/lib/time"
 	. "go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
 	"go.starlark.net/syntax"
 )
 
@@ -44,7 +53,72 @@ func (m tmethod) String() string {
 func (m tmethod) Type() string { return "builtin_method" }
 func (m tmethod) Truth() Bool  { return true }
 func (m tmethod) CallInternal(thread *Thread, args Tuple, kwargs []Tuple) (Value, error) {
-	return m.fn(m.tb, thread, args, kwargs)
+	if isNilTB(m.tb) {
+		return nil, fmt.Errorf("%s: no test set on %s; create it with a non-nil testing value", m.name, m.recv.Type())
+	}
+	if redact, ok := thread.Local(redactorKey).(func(string) string); ok {
+		print := thread.Print
+		thread.Print = func(thread *Thread, msg string) { print(thread, redact(msg)) }
+		defer func() { thread.Print = print }()
+	}
+	tb := m.tb
+	if workers, ok := thread.Local(benchWorkersKey).(*benchWorkers); ok {
+		tb = newWorkerTB(tb, workers)
+	}
+	if traces, _ := thread.Local(stackTracesKey).(bool); traces {
+		return m.fn(&stackTB{TB: tb, thread: thread}, thread, args, kwargs)
+	}
+	return m.fn(tb, thread, args, kwargs)
+}
+
+// redactorKey is the thread local storing the func of WithRedactor.
+const redactorKey = "starlarkassert.redactor"
+
+// stackTracesKey is the thread local set by WithStackTraces.
+const stackTracesKey = "starlarkassert.stacktraces"
+
+// stackTB prints the starlark call stack, once, after the first failure
+// reported.
+type stackTB struct {
+	testing.TB
+	thread  *Thread
+	printed bool
+}
+
+func (s *stackTB) printStack() {
+	if s.printed {
+		return
+	}
+	s.printed = true
+	stack := s.thread.CallStack()
+	stack.Pop() // the assertion's builtin
+	s.thread.Print(s.thread, strings.TrimSuffix(stack.String(), "\n"))
+}
+
+func (s *stackTB) Fail()                     { s.printStack(); s.TB.Fail() }
+func (s *stackTB) FailNow()                  { s.printStack(); s.TB.FailNow() }
+func (s *stackTB) Error(args ...interface{}) { s.TB.Error(args...); s.printStack() }
+func (s *stackTB) Errorf(format string, args ...interface{}) {
+	s.TB.Errorf(format, args...)
+	s.printStack()
+}
+func (s *stackTB) Fatal(args ...interface{}) { s.TB.Log(args...); s.FailNow() }
+func (s *stackTB) Fatalf(format string, args ...interface{}) {
+	s.TB.Logf(format, args...)
+	s.FailNow()
+}
+
+// isNilTB reports whether tb is nil, including a nil *testing.T or *testing.B.
+func isNilTB(tb testing.TB) bool {
+	switch tb := tb.(type) {
+	case nil:
+		return true
+	case *testing.T:
+		return tb == nil
+	case *testing.B:
+		return tb == nil
+	}
+	return false
 }
 
 var print_ = Universe["print"].(*Builtin)
@@ -79,15 +153,45 @@ func terror(t testing.TB, thread *Thread, args Tuple, kwargs []Tuple) (Value, er
 	}
 	thread.Print(thread, s)
 	t.Fail()
+	if limit, ok := thread.Local(maxErrorsKey).(*errorLimit); ok {
+		if n := atomic.AddInt64(&limit.count, 1); n >= int64(limit.max) {
+			thread.Print(thread, fmt.Sprintf("stopping after %d errors", n))
+			t.FailNow()
+		}
+	}
 	return True, nil
 }
 
+// maxErrorsKey is the thread local storing the *errorLimit of WithMaxErrors.
+const maxErrorsKey = "starlarkassert.maxerrors"
+
+// errorLimit counts the errors reported by a test, including by the threads
+// of b.run_parallel.
+type errorLimit struct {
+	count int64 // atomic
+	max   int
+}
+
 func tskip(t testing.TB, thread *Thread, args Tuple, kwargs []Tuple) (Value, error) {
 	s, err := pprint(thread, args, kwargs)
 	if err != nil {
 		return nil, err
 	}
-	t.Skip(s)
+	if noSkip, _ := thread.Local(noSkipKey).(bool); noSkip {
+		msg := "skip not allowed"
+		if s != "" {
+			msg += ": " + s
+		}
+		thread.Print(thread, msg)
+		t.FailNow()
+		return False, nil
+	}
+	// Without a message skip quietly rather than logging an empty line.
+	if len(args) == 0 && len(kwargs) == 0 {
+		t.SkipNow()
+	} else {
+		t.Skip(s)
+	}
 	return True, nil
 }
 
@@ -101,6 +205,27 @@ func tfatal(t testing.TB, thread *Thread, args Tuple, kwargs []Tuple) (Value, er
 	return True, nil
 }
 
+// tfatalf formats args with Starlark's % operator, like Go's Fatalf.
+func tfatalf(t testing.TB, thread *Thread, args Tuple, kwargs []Tuple) (Value, error) {
+	if len(kwargs) > 0 {
+		return nil, fmt.Errorf("fatalf does not accept keyword arguments")
+	}
+	var format String
+	if len(args) == 0 {
+		return nil, fmt.Errorf("fatalf: missing argument for format")
+	}
+	if err := UnpackPositionalArgs("fatalf", args[:1], nil, 1, &format); err != nil {
+		return nil, err
+	}
+	s, err := Binary(syntax.PERCENT, format, args[1:])
+	if err != nil {
+		return nil, fmt.Errorf("fatalf: %v", err)
+	}
+	thread.Print(thread, string(s.(String)))
+	t.FailNow()
+	return True, nil
+}
+
 func tfail(t testing.TB, _ *Thread, args Tuple, kwargs []Tuple) (Value, error) {
 	if len(args) > 0 || len(kwargs) > 0 {
 		return nil, fmt.Errorf("fail does not accept arguments")
@@ -109,43 +234,153 @@ func tfail(t testing.TB, _ *Thread, args Tuple, kwargs []Tuple) (Value, error) {
 	return True, nil
 }
 
+// tfailed reports whether the test has failed so far.
+func tfailed(t testing.TB, _ *Thread, args Tuple, kwargs []Tuple) (Value, error) {
+	if err := UnpackArgs("failed", args, kwargs); err != nil {
+		return nil, err
+	}
+	return Bool(t.Failed()), nil
+}
+
 func teq(t testing.TB, thread *Thread, args Tuple, kwargs []Tuple) (Value, error) {
 	var x, y Value
 	if err := UnpackArgs("eq", args, kwargs, "x", &x, "y", &y); err != nil {
 		return nil, err
 	}
-	ok, err := Equal(x, y)
+	ok, err := equal(thread, x, y)
 	if err != nil {
 		return nil, err
 	}
 	if !ok {
-		if v, diffOk := x.(Diffable); diffOk {
-			str, err := v.DiffSameType(y)
-			if err != nil {
-				return nil, err
-			}
-			thread.Print(thread, str)
-			t.Fail()
-		} else {
-			str := fmt.Sprintf("%q != %q", x.String(), y.String())
-			thread.Print(thread, str)
-			t.Fail()
+		var str string
+		if x.Type() != y.Type() && sideBySide(thread) {
+			str = sideBySideDiff(x, y)
+		} else if x.Type() != y.Type() {
+			str = fmt.Sprintf("got %s %s, want %s %s", x.Type(), shortRepr(x), y.Type(), shortRepr(y))
+		} else if str, err = diffMessage(thread, x, y); err != nil {
+			return nil, err
 		}
+		thread.Print(thread, str)
+		t.Fail()
 	}
 	return Bool(ok), nil
 }
 
+// teqIter compares the elements of two iterables in lockstep, stopping at the
+// first difference, so that neither is materialized.
+func teqIter(t testing.TB, thread *Thread, args Tuple, kwargs []Tuple) (Value, error) {
+	var x, y Iterable
+	if err := UnpackArgs("eq_iter", args, kwargs, "x", &x, "y", &y); err != nil {
+		return nil, err
+	}
+	xi, yi := x.Iterate(), y.Iterate()
+	defer xi.Done()
+	defer yi.Done()
+
+	var msg string
+	for i := 0; ; i++ {
+		var xv, yv Value
+		xok, yok := xi.Next(&xv), yi.Next(&yv)
+		if !xok && !yok {
+			return True, nil
+		}
+		if !xok {
+			msg = fmt.Sprintf("x ended at index %d, y continues with %s", i, shortRepr(yv))
+			break
+		}
+		if !yok {
+			msg = fmt.Sprintf("y ended at index %d, x continues with %s", i, shortRepr(xv))
+			break
+		}
+		ok, err := Equal(xv, yv)
+		if err != nil {
+			return nil, fmt.Errorf("eq_iter: index %d: %v", i, err)
+		}
+		if !ok {
+			msg = fmt.Sprintf("index %d: %s != %s", i, shortRepr(xv), shortRepr(yv))
+			break
+		}
+	}
+	thread.Print(thread, msg)
+	t.Fail()
+	return False, nil
+}
+
+// normalizeText converts line endings to \n, optionally stripping trailing
+// whitespace from each line.
+func normalizeText(s string, stripTrailing bool) string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	if !stripTrailing {
+		return s
+	}
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t\r")
+	}
+	return strings.Join(lines, "\n")
+}
+
+func teqText(t testing.TB, thread *Thread, args Tuple, kwargs []Tuple) (Value, error) {
+	var (
+		x, y          string
+		stripTrailing = true
+	)
+	if err := UnpackArgs("eq_text", args, kwargs, "x", &x, "y", &y, "strip_trailing?", &stripTrailing); err != nil {
+		return nil, err
+	}
+	x, y = normalizeText(x, stripTrailing), normalizeText(y, stripTrailing)
+	if x != y {
+		thread.Print(thread, lineDiff(x, y, diffContext(thread)))
+		t.Fail()
+		return False, nil
+	}
+	return True, nil
+}
+
+// identical reports whether x and y are the same reference, or equal values
+// for types without identity.
+func identical(x, y Value) bool {
+	xv, yv := reflect.ValueOf(x), reflect.ValueOf(y)
+	if xv.Type() != yv.Type() {
+		return false
+	}
+	switch xv.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		return xv.Pointer() == yv.Pointer()
+	case reflect.Slice:
+		return xv.Pointer() == yv.Pointer() && xv.Len() == yv.Len()
+	}
+	return xv.Type().Comparable() && x == y
+}
+
+func tisSame(t testing.TB, thread *Thread, args Tuple, kwargs []Tuple) (Value, error) {
+	var x, y Value
+	if err := UnpackArgs("is_same", args, kwargs, "x", &x, "y", &y); err != nil {
+		return nil, err
+	}
+	if !identical(x, y) {
+		msg := fmt.Sprintf("expected the same reference, got %s %s and %s %s", x.Type(), shortRepr(x), y.Type(), shortRepr(y))
+		thread.Print(thread, msg)
+		t.Fail()
+		return False, nil
+	}
+	return True, nil
+}
+
 func tne(t testing.TB, thread *Thread, args Tuple, kwargs []Tuple) (Value, error) {
 	var x, y Value
 	if err := UnpackArgs("ne", args, kwargs, "x", &x, "y", &y); err != nil {
 		return nil, err
 	}
-	ok, err := Equal(x, y)
+	ok, err := equal(thread, x, y)
 	if err != nil {
 		return nil, err
 	}
 	if ok {
 		str := fmt.Sprintf("%q != %q", x.String(), y.String())
+		if x.Type() != y.Type() {
+			str = fmt.Sprintf("got %s %s, equal to %s %s", x.Type(), shortRepr(x), y.Type(), shortRepr(y))
+		}
 		thread.Print(thread, str)
 		t.Fail()
 	}
@@ -167,6 +402,32 @@ func ttrue(t testing.TB, thread *Thread, args Tuple, kwargs []Tuple) (Value, err
 	return cond.Truth(), nil
 }
 
+func tisTruthy(t testing.TB, thread *Thread, args Tuple, kwargs []Tuple) (Value, error) {
+	var x Value
+	if err := UnpackArgs("is_truthy", args, kwargs, "x", &x); err != nil {
+		return nil, err
+	}
+	if !x.Truth() {
+		msg := fmt.Sprintf("expected truthy value, got %s", shortRepr(x))
+		thread.Print(thread, msg)
+		t.Fail()
+	}
+	return x.Truth(), nil
+}
+
+func tisFalsy(t testing.TB, thread *Thread, args Tuple, kwargs []Tuple) (Value, error) {
+	var x Value
+	if err := UnpackArgs("is_falsy", args, kwargs, "x", &x); err != nil {
+		return nil, err
+	}
+	if x.Truth() {
+		msg := fmt.Sprintf("expected falsy value, got %s", shortRepr(x))
+		thread.Print(thread, msg)
+		t.Fail()
+	}
+	return !x.Truth(), nil
+}
+
 func tlt(t testing.TB, thread *Thread, args Tuple, kwargs []Tuple) (Value, error) {
 	var x, y Value
 	if err := UnpackArgs("lt", args, kwargs, "x", &x, "y", &y); err != nil {
@@ -184,6 +445,71 @@ func tlt(t testing.TB, thread *Thread, args Tuple, kwargs []Tuple) (Value, error
 	return Bool(ok), nil
 }
 
+func twithinRange(t testing.TB, thread *Thread, args Tuple, kwargs []Tuple) (Value, error) {
+	var (
+		x, lo, hi Value
+		inclusive = true
+	)
+	if err := UnpackArgs("within_range", args, kwargs, "x", &x, "lo", &lo, "hi", &hi, "inclusive?", &inclusive); err != nil {
+		return nil, err
+	}
+	op, below, above := syntax.LE, "less than lower bound", "greater than upper bound"
+	if !inclusive {
+		op, below, above = syntax.LT, "not greater than lower bound", "not less than upper bound"
+	}
+
+	var msg string
+	if ok, err := Compare(op, lo, x); err != nil {
+		return nil, err
+	} else if !ok {
+		msg = fmt.Sprintf("%s is %s %s", x, below, lo)
+	} else if ok, err := Compare(op, x, hi); err != nil {
+		return nil, err
+	} else if !ok {
+		msg = fmt.Sprintf("%s is %s %s", x, above, hi)
+	}
+	if msg != "" {
+		thread.Print(thread, msg)
+		t.Fail()
+		return False, nil
+	}
+	return True, nil
+}
+
+func tisSorted(t testing.TB, thread *Thread, args Tuple, kwargs []Tuple) (Value, error) {
+	var (
+		x       Iterable
+		reverse bool
+	)
+	if err := UnpackArgs("is_sorted", args, kwargs, "x", &x, "reverse?", &reverse); err != nil {
+		return nil, err
+	}
+	op, sym := syntax.GT, ">"
+	if reverse {
+		op, sym = syntax.LT, "<"
+	}
+
+	iter := x.Iterate()
+	defer iter.Done()
+	var prev, p Value
+	for i := 0; iter.Next(&p); i++ {
+		if i > 0 {
+			ok, err := Compare(op, prev, p)
+			if err != nil {
+				return nil, fmt.Errorf("is_sorted: index %d: %v", i, err)
+			}
+			if ok {
+				msg := fmt.Sprintf("elements %d and %d are out of order: %s %s %s", i-1, i, prev, sym, p)
+				thread.Print(thread, msg)
+				t.Fail()
+				return False, nil
+			}
+		}
+		prev = p
+	}
+	return True, nil
+}
+
 func tcontains(t testing.TB, thread *Thread, args Tuple, kwargs []Tuple) (Value, error) {
 	var (
 		x Iterable
@@ -192,6 +518,21 @@ func tcontains(t testing.TB, thread *Thread, args Tuple, kwargs []Tuple) (Value,
 	if err := UnpackArgs("contains", args, kwargs, "x", &x, "y", &y); err != nil {
 		return nil, err
 	}
+	ok, err := contains(x, y)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return True, nil
+	}
+	msg := fmt.Sprintf("%s does not contain %s", x, y)
+	thread.Print(thread, msg)
+	t.Fail()
+	return False, nil
+}
+
+// contains reports whether y is an element of x.
+func contains(x Iterable, y Value) (bool, error) {
 	iter := x.Iterate()
 	defer iter.Done()
 
@@ -199,18 +540,165 @@ func tcontains(t testing.TB, thread *Thread, args Tuple, kwargs []Tuple) (Value,
 	for iter.Next(&p) {
 		ok, err := Equal(y, p)
 		if err != nil {
-			return nil, err
+			return false, err
 		}
 		if ok {
-			return True, nil
+			return true, nil
 		}
 	}
-	msg := fmt.Sprintf("%s does not contain %s", x, y)
+	return false, nil
+}
+
+// partitionContains splits items into those contained and missing in x.
+func partitionContains(x, items Iterable) (found, missing []Value, err error) {
+	iter := items.Iterate()
+	defer iter.Done()
+
+	var p Value
+	for iter.Next(&p) {
+		ok, err := contains(x, p)
+		if err != nil {
+			return nil, nil, err
+		}
+		if ok {
+			found = append(found, p)
+		} else {
+			missing = append(missing, p)
+		}
+	}
+	return found, missing, nil
+}
+
+func tcontainsAll(t testing.TB, thread *Thread, args Tuple, kwargs []Tuple) (Value, error) {
+	var x, items Iterable
+	if err := UnpackArgs("contains_all", args, kwargs, "x", &x, "items", &items); err != nil {
+		return nil, err
+	}
+	_, missing, err := partitionContains(x, items)
+	if err != nil {
+		return nil, err
+	}
+	if len(missing) > 0 {
+		msg := fmt.Sprintf("%s does not contain %s", x, NewList(missing))
+		thread.Print(thread, msg)
+		t.Fail()
+		return False, nil
+	}
+	return True, nil
+}
+
+func tcontainsAny(t testing.TB, thread *Thread, args Tuple, kwargs []Tuple) (Value, error) {
+	var x, items Iterable
+	if err := UnpackArgs("contains_any", args, kwargs, "x", &x, "items", &items); err != nil {
+		return nil, err
+	}
+	found, missing, err := partitionContains(x, items)
+	if err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		msg := fmt.Sprintf("%s contains none of %s", x, NewList(missing))
+		thread.Print(thread, msg)
+		t.Fail()
+		return False, nil
+	}
+	return True, nil
+}
+
+// tcontainsSubstring checks needle is a substring of haystack. Unlike contains
+// the strings are compared as a whole, not by element.
+func tcontainsSubstring(t testing.TB, thread *Thread, args Tuple, kwargs []Tuple) (Value, error) {
+	var haystack, needle String
+	if err := UnpackArgs("contains_substring", args, kwargs, "haystack", &haystack, "needle", &needle); err != nil {
+		return nil, err
+	}
+	if strings.Contains(string(haystack), string(needle)) {
+		return True, nil
+	}
+	msg := fmt.Sprintf("%s does not contain substring %s", haystack, needle)
 	thread.Print(thread, msg)
 	t.Fail()
 	return False, nil
 }
 
+// pairs returns the elements of x, checking each is a (key, value) pair.
+func pairs(name, param string, x Iterable) ([]Value, error) {
+	var elems []Value
+	iter := x.Iterate()
+	defer iter.Done()
+	var p Value
+	for iter.Next(&p) {
+		if pair, ok := p.(Tuple); !ok || len(pair) != 2 {
+			return nil, fmt.Errorf("%s: for parameter %s: index %d: got %s, want (key, value) pair", name, param, len(elems), shortRepr(p))
+		}
+		elems = append(elems, p)
+	}
+	return elems, nil
+}
+
+// unmatched returns the elements of x without an equal element in y, matching
+// each element of y at most once.
+func unmatched(x, y []Value) ([]Value, error) {
+	used := make([]bool, len(y))
+	var missing []Value
+outer:
+	for _, xv := range x {
+		for i, yv := range y {
+			if used[i] {
+				continue
+			}
+			if ok, err := Equal(xv, yv); err != nil {
+				return nil, err
+			} else if ok {
+				used[i] = true
+				continue outer
+			}
+		}
+		missing = append(missing, xv)
+	}
+	return missing, nil
+}
+
+func teqPairs(t testing.TB, thread *Thread, args Tuple, kwargs []Tuple) (Value, error) {
+	var x, y Iterable
+	if err := UnpackArgs("eq_pairs", args, kwargs, "x", &x, "y", &y); err != nil {
+		return nil, err
+	}
+	xs, err := pairs("eq_pairs", "x", x)
+	if err != nil {
+		return nil, err
+	}
+	ys, err := pairs("eq_pairs", "y", y)
+	if err != nil {
+		return nil, err
+	}
+
+	onlyX, err := unmatched(xs, ys)
+	if err != nil {
+		return nil, err
+	}
+	onlyY, err := unmatched(ys, xs)
+	if err != nil {
+		return nil, err
+	}
+	if len(onlyX) == 0 && len(onlyY) == 0 {
+		return True, nil
+	}
+
+	sortValues(onlyX)
+	sortValues(onlyY)
+	var d differ
+	for _, v := range onlyX {
+		d.addf("", "%s missing from y", v)
+	}
+	for _, v := range onlyY {
+		d.addf("", "%s missing from x", v)
+	}
+	thread.Print(thread, d.String())
+	t.Fail()
+	return False, nil
+}
+
 func tfails(t testing.TB, thread *Thread, args Tuple, kwargs []Tuple) (Value, error) {
 	var (
 		f       Callable
@@ -240,3 +728,693 @@ func tfails(t testing.TB, thread *Thread, args Tuple, kwargs []Tuple) (Value, er
 	}
 	return Bool(ok), nil
 }
+
+// typeErrorPattern matches the messages of starlark's type errors.
+var typeErrorPattern = regexp.MustCompile(`\bgot\b|\bwant\b|unhashable|not iterable|unknown (binary|unary) op|non-function|of type`)
+
+// tassertTypeError calls fn with the remaining positional args and checks it
+// fails with a type error, optionally matching pattern.
+func tassertTypeError(t testing.TB, thread *Thread, args Tuple, kwargs []Tuple) (Value, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("assert_type_error: missing argument for fn")
+	}
+	fn, ok := args[0].(Callable)
+	if !ok {
+		return nil, fmt.Errorf("assert_type_error: for parameter fn: got %s, want callable", args[0].Type())
+	}
+	var pattern string
+	if err := UnpackArgs("assert_type_error", nil, kwargs, "pattern?", &pattern); err != nil {
+		return nil, err
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("assert_type_error: %v", err)
+	}
+
+	_, err = Call(thread, fn, args[1:], nil)
+	if err == nil {
+		msg := "evaluation succeeded unexpectedly (want type error)"
+		thread.Print(thread, msg)
+		t.Fail()
+		return False, nil
+	}
+	str := err.Error()
+	if evalErr, ok := err.(*EvalError); ok {
+		str = evalErr.Msg
+	}
+	if !typeErrorPattern.MatchString(str) {
+		msg := fmt.Sprintf("error is not a type error: %s", str)
+		thread.Print(thread, msg)
+		t.Fail()
+		return False, nil
+	}
+	if !re.MatchString(str) {
+		msg := fmt.Sprintf("regular expression (%s) did not match type error (%s)", pattern, str)
+		thread.Print(thread, msg)
+		t.Fail()
+		return False, nil
+	}
+	return True, nil
+}
+
+// approxEqual reports whether x and y are within rel relative tolerance or
+// abs absolute tolerance of each other, like Python's math.isclose.
+// Infinities are only equal to the same infinity and NaN is equal to nothing.
+func approxEqual(x, y, rel, abs float64) bool {
+	if x == y {
+		return true
+	}
+	if math.IsInf(x, 0) || math.IsInf(y, 0) {
+		return false
+	}
+	return math.Abs(x-y) <= math.Max(rel*math.Max(math.Abs(x), math.Abs(y)), abs)
+}
+
+func tapprox(t testing.TB, thread *Thread, args Tuple, kwargs []Tuple) (Value, error) {
+	var (
+		xv, yv Value
+		rel    = 1e-9
+		abs    float64
+	)
+	if err := UnpackArgs("approx", args, kwargs, "x", &xv, "y", &yv, "rel?", &rel, "abs?", &abs); err != nil {
+		return nil, err
+	}
+	x, ok := AsFloat(xv)
+	if !ok {
+		return nil, fmt.Errorf("approx: for parameter x: got %s, want float or int", xv.Type())
+	}
+	y, ok := AsFloat(yv)
+	if !ok {
+		return nil, fmt.Errorf("approx: for parameter y: got %s, want float or int", yv.Type())
+	}
+
+	var msg string
+	switch {
+	case math.IsNaN(x) || math.IsNaN(y):
+		msg = fmt.Sprintf("NaN is not approximately equal: %v, %v", x, y)
+	case math.IsInf(x, 0) || math.IsInf(y, 0):
+		if x != y {
+			msg = fmt.Sprintf("%v != %v", x, y)
+		}
+	case !approxEqual(x, y, rel, abs):
+		msg = fmt.Sprintf("%v != %v (delta %v)", x, y, math.Abs(x-y))
+	}
+	if msg != "" {
+		thread.Print(thread, msg)
+		t.Fail()
+		return False, nil
+	}
+	return True, nil
+}
+
+func tdurationApprox(t testing.TB, thread *Thread, args Tuple, kwargs []Tuple) (Value, error) {
+	var x, y, tol starlarktime.Duration
+	if err := UnpackArgs("duration_approx", args, kwargs, "x", &x, "y", &y, "tol", &tol); err != nil {
+		return nil, err
+	}
+	if !approxEqual(float64(x), float64(y), 0, float64(tol)) {
+		delta := time.Duration(x - y)
+		if delta < 0 {
+			delta = -delta
+		}
+		thread.Print(thread, fmt.Sprintf("%s != %s (delta %s, tolerance %s)", x, y, delta, tol))
+		t.Fail()
+		return False, nil
+	}
+	return True, nil
+}
+
+func tbitsEq(t testing.TB, thread *Thread, args Tuple, kwargs []Tuple) (Value, error) {
+	var x, y float64
+	if err := UnpackArgs("bits_eq", args, kwargs, "x", &x, "y", &y); err != nil {
+		return nil, err
+	}
+	xb, yb := math.Float64bits(x), math.Float64bits(y)
+	if xb != yb {
+		msg := fmt.Sprintf("%#016x != %#016x (%v != %v)", xb, yb, x, y)
+		thread.Print(thread, msg)
+		t.Fail()
+		return False, nil
+	}
+	return True, nil
+}
+
+func floats(name string, x Iterable) ([]float64, error) {
+	iter := x.Iterate()
+	defer iter.Done()
+
+	var (
+		fs []float64
+		p  Value
+	)
+	for iter.Next(&p) {
+		f, ok := AsFloat(p)
+		if !ok {
+			return nil, fmt.Errorf("%s: got %s at index %d, want float or int", name, p.Type(), len(fs))
+		}
+		fs = append(fs, f)
+	}
+	return fs, nil
+}
+
+func tapproxEqList(t testing.TB, thread *Thread, args Tuple, kwargs []Tuple) (Value, error) {
+	var (
+		x, y Iterable
+		rel  = 1e-9
+		abs  float64
+	)
+	if err := UnpackArgs("approx_eq_list", args, kwargs, "x", &x, "y", &y, "rel?", &rel, "abs?", &abs); err != nil {
+		return nil, err
+	}
+	xs, err := floats("approx_eq_list", x)
+	if err != nil {
+		return nil, err
+	}
+	ys, err := floats("approx_eq_list", y)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(xs) != len(ys) {
+		msg := fmt.Sprintf("length mismatch: %d != %d", len(xs), len(ys))
+		thread.Print(thread, msg)
+		t.Fail()
+		return False, nil
+	}
+	for i := range xs {
+		if !approxEqual(xs[i], ys[i], rel, abs) {
+			msg := fmt.Sprintf("index %d: %v != %v (delta %v)", i, xs[i], ys[i], math.Abs(xs[i]-ys[i]))
+			thread.Print(thread, msg)
+			t.Fail()
+			return False, nil
+		}
+	}
+	return True, nil
+}
+
+func tsameResult(t testing.TB, thread *Thread, args Tuple, kwargs []Tuple) (Value, error) {
+	if len(args) < 2 {
+		return nil, fmt.Errorf("same_result: got %d arguments, want at least 2", len(args))
+	}
+	f, ok := args[0].(Callable)
+	if !ok {
+		return nil, fmt.Errorf("same_result: for parameter 1: got %s, want callable", args[0].Type())
+	}
+	g, ok := args[1].(Callable)
+	if !ok {
+		return nil, fmt.Errorf("same_result: for parameter 2: got %s, want callable", args[1].Type())
+	}
+
+	x, xerr := Call(thread, f, args[2:], kwargs)
+	y, yerr := Call(thread, g, args[2:], kwargs)
+	var msg string
+	switch {
+	case xerr != nil && yerr != nil:
+		msg = fmt.Sprintf("%s failed: %v\n%s failed: %v", f.Name(), xerr, g.Name(), yerr)
+	case xerr != nil:
+		msg = fmt.Sprintf("%s failed: %v\n%s returned: %s", f.Name(), xerr, g.Name(), y)
+	case yerr != nil:
+		msg = fmt.Sprintf("%s returned: %s\n%s failed: %v", f.Name(), x, g.Name(), yerr)
+	default:
+		ok, err := Equal(x, y)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return True, nil
+		}
+		if msg, err = diffMessage(thread, x, y); err != nil {
+			return nil, err
+		}
+	}
+	thread.Print(thread, msg)
+	t.Fail()
+	return False, nil
+}
+
+func logValue(thread *Thread, args Tuple, kwargs []Tuple) (Value, error) {
+	var x Value
+	if err := UnpackArgs("log_value", args, kwargs, "x", &x); err != nil {
+		return nil, err
+	}
+	thread.Print(thread, prettyString(x))
+	return None, nil
+}
+
+func sortedItems(_ *Thread, args Tuple, kwargs []Tuple) (Value, error) {
+	var d *Dict
+	if err := UnpackArgs("sorted_items", args, kwargs, "d", &d); err != nil {
+		return nil, err
+	}
+	items := d.Items()
+
+	var err error
+	sort.SliceStable(items, func(i, j int) bool {
+		ok, cerr := Compare(syntax.LT, items[i][0], items[j][0])
+		if cerr != nil && err == nil {
+			err = cerr
+		}
+		return ok
+	})
+	if err != nil {
+		return nil, fmt.Errorf("sorted_items: %v", err)
+	}
+
+	elems := make([]Value, len(items))
+	for i, item := range items {
+		elems[i] = item
+	}
+	return NewList(elems), nil
+}
+
+func capture(thread *Thread, args Tuple, kwargs []Tuple) (Value, error) {
+	var fn Callable
+	if err := UnpackArgs("capture", args, kwargs, "fn", &fn); err != nil {
+		return nil, err
+	}
+
+	var buf strings.Builder
+	oldPrint := thread.Print
+	thread.Print = func(_ *Thread, msg string) {
+		buf.WriteString(msg)
+		buf.WriteByte('\n')
+	}
+	defer func() { thread.Print = oldPrint }()
+
+	if _, err := Call(thread, fn, nil, nil); err != nil {
+		return nil, err
+	}
+	return String(buf.String()), nil
+}
+
+func unpackBytes(name string, args Tuple, kwargs []Tuple) (x, y Bytes, err error) {
+	var xv, yv Value
+	if err := UnpackArgs(name, args, kwargs, "x", &xv, "y", &yv); err != nil {
+		return "", "", err
+	}
+	var ok bool
+	if x, ok = xv.(Bytes); !ok {
+		return "", "", fmt.Errorf("%s: for parameter x: got %s, want bytes", name, xv.Type())
+	}
+	if y, ok = yv.(Bytes); !ok {
+		return "", "", fmt.Errorf("%s: for parameter y: got %s, want bytes", name, yv.Type())
+	}
+	return x, y, nil
+}
+
+// hexDiff formats both byte strings as hex, noting the first differing offset.
+func hexDiff(x, y Bytes) string {
+	i := 0
+	for i < len(x) && i < len(y) && x[i] == y[i] {
+		i++
+	}
+	return fmt.Sprintf("bytes differ at offset %d:\nx: % x\ny: % x", i, string(x), string(y))
+}
+
+func tbytesEq(t testing.TB, thread *Thread, args Tuple, kwargs []Tuple) (Value, error) {
+	x, y, err := unpackBytes("bytes_eq", args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	if x != y {
+		thread.Print(thread, hexDiff(x, y))
+		t.Fail()
+		return False, nil
+	}
+	return True, nil
+}
+
+func tbytesLt(t testing.TB, thread *Thread, args Tuple, kwargs []Tuple) (Value, error) {
+	x, y, err := unpackBytes("bytes_lt", args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	if !(x < y) {
+		msg := fmt.Sprintf("x is not less than y, %s", hexDiff(x, y))
+		thread.Print(thread, msg)
+		t.Fail()
+		return False, nil
+	}
+	return True, nil
+}
+
+func typeOf(_ *Thread, args Tuple, kwargs []Tuple) (Value, error) {
+	var x Value
+	if err := UnpackArgs("type_of", args, kwargs, "x", &x); err != nil {
+		return nil, err
+	}
+	return String(x.Type()), nil
+}
+
+// measureAllocs returns the average number of heap allocations of a call to
+// fn over runs calls, after a warm up call, like testing.AllocsPerRun. As with
+// testing.AllocsPerRun, GOMAXPROCS is set to 1 for the whole process while it
+// runs, slowing any parallel tests, and their allocations are counted too.
+func measureAllocs(thread *Thread, args Tuple, kwargs []Tuple) (Value, error) {
+	var (
+		fn   Callable
+		runs = 100
+	)
+	if err := UnpackArgs("measure_allocs", args, kwargs, "fn", &fn, "runs?", &runs); err != nil {
+		return nil, err
+	}
+	if runs < 1 {
+		return nil, fmt.Errorf("measure_allocs: runs must be positive, got %d", runs)
+	}
+
+	var err error
+	allocs := testing.AllocsPerRun(runs, func() {
+		if err == nil {
+			_, err = Call(thread, fn, nil, nil)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return MakeInt(int(allocs)), nil
+}
+
+func teqRepr(t testing.TB, thread *Thread, args Tuple, kwargs []Tuple) (Value, error) {
+	var (
+		value Value
+		want  string
+	)
+	if err := UnpackArgs("eq_repr", args, kwargs, "value", &value, "repr", &want); err != nil {
+		return nil, err
+	}
+	got := value.String()
+	if got == want {
+		return True, nil
+	}
+
+	i := 0
+	for i < len(got) && i < len(want) && got[i] == want[i] {
+		i++
+	}
+	msg := fmt.Sprintf("repr differs at position %d:\n got: %s\nwant: %s\n      %s^", i, got, want, strings.Repeat(" ", i))
+	thread.Print(thread, msg)
+	t.Fail()
+	return False, nil
+}
+
+// callRecover calls fn recovering any panic. The call is made on a new thread
+// as a panic leaves the thread's call stack unwound.
+func callRecover(thread *Thread, fn Callable) (recovered interface{}, err error) {
+	thread = childThread(thread)
+	defer func() { recovered = recover() }()
+	_, err = Call(thread, fn, nil, nil)
+	return nil, err
+}
+
+func tpanicsWithType(t testing.TB, thread *Thread, args Tuple, kwargs []Tuple) (Value, error) {
+	var (
+		fn       Callable
+		typeName string
+	)
+	if err := UnpackArgs("panics_with_type", args, kwargs, "fn", &fn, "type_name", &typeName); err != nil {
+		return nil, err
+	}
+
+	r, err := callRecover(thread, fn)
+	var msg string
+	switch {
+	case r != nil:
+		got := reflect.TypeOf(r).String()
+		if got == typeName {
+			return True, nil
+		}
+		msg = fmt.Sprintf("panic of type %s, want %s: %v", got, typeName, r)
+	case err != nil:
+		msg = fmt.Sprintf("failed without panic (want panic of type %s): %v", typeName, err)
+	default:
+		msg = fmt.Sprintf("did not panic (want panic of type %s)", typeName)
+	}
+	thread.Print(thread, msg)
+	t.Fail()
+	return False, nil
+}
+
+// withoutFields copies dicts and structs in v removing the dotted paths.
+func withoutFields(v Value, paths [][]string) (Value, error) {
+	// split returns whether name is removed and the paths nested under it.
+	split := func(name string) (bool, [][]string) {
+		var nested [][]string
+		for _, path := range paths {
+			if path[0] != name {
+				continue
+			}
+			if len(path) == 1 {
+				return true, nil
+			}
+			nested = append(nested, path[1:])
+		}
+		return false, nested
+	}
+
+	switch v := v.(type) {
+	case *Dict:
+		d := NewDict(v.Len())
+		for _, item := range v.Items() {
+			k, val := item[0], item[1]
+			if s, ok := k.(String); ok {
+				removed, nested := split(string(s))
+				if removed {
+					continue
+				}
+				if len(nested) > 0 {
+					var err error
+					if val, err = withoutFields(val, nested); err != nil {
+						return nil, err
+					}
+				}
+			}
+			if err := d.SetKey(k, val); err != nil {
+				return nil, err
+			}
+		}
+		return d, nil
+	case *starlarkstruct.Struct:
+		d := make(StringDict)
+		for _, name := range v.AttrNames() {
+			removed, nested := split(name)
+			if removed {
+				continue
+			}
+			val, err := v.Attr(name)
+			if err != nil {
+				return nil, err
+			}
+			if len(nested) > 0 {
+				if val, err = withoutFields(val, nested); err != nil {
+					return nil, err
+				}
+			}
+			d[name] = val
+		}
+		return starlarkstruct.FromStringDict(v.Constructor(), d), nil
+	}
+	return v, nil
+}
+
+func teqIgnoring(t testing.TB, thread *Thread, args Tuple, kwargs []Tuple) (Value, error) {
+	var (
+		x, y   Value
+		fields *List
+	)
+	if err := UnpackArgs("eq_ignoring", args, kwargs, "x", &x, "y", &y, "fields", &fields); err != nil {
+		return nil, err
+	}
+	paths := make([][]string, fields.Len())
+	for i := range paths {
+		s, ok := AsString(fields.Index(i))
+		if !ok {
+			return nil, fmt.Errorf("eq_ignoring: for parameter fields: got %s, want string", fields.Index(i).Type())
+		}
+		paths[i] = strings.Split(s, ".")
+	}
+
+	x, err := withoutFields(x, paths)
+	if err != nil {
+		return nil, err
+	}
+	y, err = withoutFields(y, paths)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := Equal(x, y)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		str, err := diffMessage(thread, x, y)
+		if err != nil {
+			return nil, err
+		}
+		thread.Print(thread, str)
+		t.Fail()
+	}
+	return Bool(ok), nil
+}
+
+// shortRepr returns the value's repr, truncated if long.
+func shortRepr(v Value) string {
+	const max = 64
+	s := v.String()
+	if len(s) > max {
+		s = s[:max] + "..."
+	}
+	return s
+}
+
+func length(name string, x Value) (int, error) {
+	n := Len(x)
+	if n < 0 {
+		return 0, fmt.Errorf("%s: got %s, want a value with length", name, x.Type())
+	}
+	return n, nil
+}
+
+func tempty(t testing.TB, thread *Thread, args Tuple, kwargs []Tuple) (Value, error) {
+	var x Value
+	if err := UnpackArgs("empty", args, kwargs, "x", &x); err != nil {
+		return nil, err
+	}
+	n, err := length("empty", x)
+	if err != nil {
+		return nil, err
+	}
+	if n != 0 {
+		msg := fmt.Sprintf("expected empty, got length %d: %s", n, shortRepr(x))
+		thread.Print(thread, msg)
+		t.Fail()
+		return False, nil
+	}
+	return True, nil
+}
+
+func tnotEmpty(t testing.TB, thread *Thread, args Tuple, kwargs []Tuple) (Value, error) {
+	var x Value
+	if err := UnpackArgs("not_empty", args, kwargs, "x", &x); err != nil {
+		return nil, err
+	}
+	n, err := length("not_empty", x)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		msg := fmt.Sprintf("expected not empty, got length 0: %s", shortRepr(x))
+		thread.Print(thread, msg)
+		t.Fail()
+		return False, nil
+	}
+	return True, nil
+}
+
+// twithTimeout runs fn on a new thread, cancelling it after the timeout. Only
+// starlark evaluation is cancelled; blocking Go builtins will run to
+// completion.
+func twithTimeout(t testing.TB, thread *Thread, args Tuple, kwargs []Tuple) (Value, error) {
+	var (
+		d  starlarktime.Duration
+		fn Callable
+	)
+	if err := UnpackArgs("with_timeout", args, kwargs, "d", &d, "fn", &fn); err != nil {
+		return nil, err
+	}
+	timeout := time.Duration(d)
+
+	child := childThread(thread)
+	done := make(chan error, 1)
+	go func() {
+		// FailNow and SkipNow exit the goroutine before Call returns.
+		err := errExited
+		defer func() { done <- err }()
+		_, err = Call(child, fn, nil, nil)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-done:
+		if err == errExited {
+			// Stop the test goroutine too, as the function's goroutine
+			// can't stop it.
+			if t.Skipped() && !t.Failed() {
+				t.SkipNow()
+			}
+			t.FailNow()
+		}
+		if err != nil {
+			return nil, err
+		}
+		return True, nil
+	case <-timer.C:
+		child.Cancel("timeout")
+		<-done
+		msg := fmt.Sprintf("timed out after %s", timeout)
+		thread.Print(thread, msg)
+		t.Fail()
+		return False, nil
+	}
+}
+
+// errExited is reported by goroutines exited by FailNow or SkipNow.
+var errExited = errors.New("goroutine exited")
+
+// matchStrings reports for each string element of x whether it matches pattern.
+func matchStrings(name string, args Tuple, kwargs []Tuple) (elems []string, matches []bool, pattern string, err error) {
+	var x Iterable
+	if err := UnpackArgs(name, args, kwargs, "x", &x, "pattern", &pattern); err != nil {
+		return nil, nil, "", err
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("%s: %v", name, err)
+	}
+
+	iter := x.Iterate()
+	defer iter.Done()
+	var p Value
+	for iter.Next(&p) {
+		s, ok := AsString(p)
+		if !ok {
+			return nil, nil, "", fmt.Errorf("%s: got %s at index %d, want string", name, p.Type(), len(elems))
+		}
+		elems = append(elems, s)
+		matches = append(matches, re.MatchString(s))
+	}
+	return elems, matches, pattern, nil
+}
+
+func tallMatch(t testing.TB, thread *Thread, args Tuple, kwargs []Tuple) (Value, error) {
+	elems, matches, pattern, err := matchStrings("all_match", args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	for i, ok := range matches {
+		if !ok {
+			msg := fmt.Sprintf("element %d %q does not match %q", i, elems[i], pattern)
+			thread.Print(thread, msg)
+			t.Fail()
+			return False, nil
+		}
+	}
+	return True, nil
+}
+
+func tanyMatch(t testing.TB, thread *Thread, args Tuple, kwargs []Tuple) (Value, error) {
+	elems, matches, pattern, err := matchStrings("any_match", args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	for _, ok := range matches {
+		if ok {
+			return True, nil
+		}
+	}
+	msg := fmt.Sprintf("none of %d elements match %q", len(elems), pattern)
+	thread.Print(thread, msg)
+	t.Fail()
+	return False, nil
+}
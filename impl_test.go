@@ -0,0 +1,1527 @@
+package starlarkassert
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	starlarktime "go.starlark.net/lib/time"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/syntax"
+)
+
+// runRecorded executes src with "t" bound to the test assertion methods,
+// recording any failures on the returned recorder.
+func runRecorded(t *testing.T, src string, opts ...TestOption) *recorder {
+	t.Helper()
+
+	r := &recorder{TB: t}
+	globals := starlark.StringDict{
+		"struct": starlark.NewBuiltin("struct", starlarkstruct.Make),
+		"set":    starlark.Universe["set"],
+		"time":   starlarktime.Module,
+	}
+	if err := r.exec("recorded.star", src, globals, opts); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// recordedTest is a snippet run by runRecordedTests, expecting failed and the
+// printed failure messages want.
+type recordedTest struct {
+	name   string
+	src    string
+	failed bool
+	want   []string
+}
+
+func runRecordedTests(t *testing.T, tests []recordedTest, opts ...TestOption) {
+	t.Helper()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := runRecorded(t, tt.src, opts...)
+			if r.failed != tt.failed {
+				t.Fatalf("failed = %v, want %v: %s", r.failed, tt.failed, r.output())
+			}
+			if got, want := r.output(), strings.Join(tt.want, "\n"); got != want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestEqualDict(t *testing.T) {
+	runRecordedTests(t, []recordedTest{{
+		name: "equal",
+		src:  `t.eq({"a": 1, 2.5: "b"}, {2.5: "b", "a": 1})`,
+	}, {
+		name:   "float_keys",
+		src:    `t.eq({1.5: 1, 2.5: 2}, {1.5: 1, 2.5: 3})`,
+		failed: true,
+		want:   []string{"[2.5]: 2 != 3"},
+	}, {
+		name: "nan_keys",
+		src:  `t.eq({float("nan"): 1}, {float("nan"): 1})`,
+	}, {
+		name:   "missing_keys",
+		src:    `t.eq({"a": 1, "b": 2}, {"a": 2, "c": 3})`,
+		failed: true,
+		want: []string{
+			`["b"]: missing from y`,
+			`["c"]: missing from x`,
+			`["a"]: 1 != 2`,
+		},
+	}, {
+		name:   "none_keys",
+		src:    `t.eq({"a": None, "b": 1}, {"b": 1, "c": None})`,
+		failed: true,
+		want: []string{
+			`["a"]: present with None in x, missing from y`,
+			`["c"]: present with None in y, missing from x`,
+		},
+	}, {
+		name:   "mixed_keys",
+		src:    `t.eq({1: "a", "1": "b"}, {"1": "b", 1.5: "a"})`,
+		failed: true,
+		want: []string{
+			`[1]: missing from y`,
+			`[1.5]: missing from x`,
+		},
+	}, {
+		name:   "nested",
+		src:    `t.eq({"a": {"b": 1}}, {"a": {"b": 2}})`,
+		failed: true,
+		want:   []string{`["a"]["b"]: 1 != 2`},
+	}, {
+		name:   "sorted_repr",
+		src:    `t.eq({"x": [{"b": 1, "a": 2}]}, {"x": [{"c": 3, "a": 2, "b": 1}]})`,
+		failed: true,
+		want:   []string{`["x"]: [{"a": 2, "b": 1}] != [{"a": 2, "b": 1, "c": 3}]`},
+	}, {
+		name:   "sorted_repr_list",
+		src:    `t.eq([{2: "b", 1: "a"}, (3,)], [{1: "a", 2: "c"}, (3,)])`,
+		failed: true,
+		want:   []string{`"[{1: \"a\", 2: \"b\"}, (3,)]" != "[{1: \"a\", 2: \"c\"}, (3,)]"`},
+	}})
+}
+
+func TestEqualStruct(t *testing.T) {
+	runRecordedTests(t, []recordedTest{{
+		name: "equal",
+		src:  `t.eq(struct(a = 1, b = "b"), struct(b = "b", a = 1))`,
+	}, {
+		name:   "changed",
+		src:    `t.eq(struct(a = 1, b = "b"), struct(a = 1, b = "c"))`,
+		failed: true,
+		want:   []string{`b: "b" != "c"`},
+	}, {
+		name:   "fields",
+		src:    `t.eq(struct(a = 1, b = 2), struct(a = 2, c = 3))`,
+		failed: true,
+		want: []string{
+			`b: missing from y`,
+			`c: missing from x`,
+			`a: 1 != 2`,
+		},
+	}, {
+		name: "nested",
+		src: `t.eq(
+    struct(name = "a", addr = struct(city = "X", geo = struct(lat = 1, lng = 2))),
+    struct(name = "a", addr = struct(city = "Y", geo = struct(lat = 1, lng = 3))),
+)`,
+		failed: true,
+		want: []string{
+			`addr.city: "X" != "Y"`,
+			`addr.geo.lng: 2 != 3`,
+		},
+	}, {
+		name:   "nested_dict",
+		src:    `t.eq(struct(tags = {"env": struct(name = "dev")}), struct(tags = {"env": struct(name = "prod")}))`,
+		failed: true,
+		want:   []string{`tags["env"].name: "dev" != "prod"`},
+	}})
+}
+
+func TestEqualSet(t *testing.T) {
+	runRecordedTests(t, []recordedTest{{
+		name: "equal",
+		src:  `t.eq(set([1, 2, 3]), set([3, 2, 1]))`,
+	}, {
+		name:   "added",
+		src:    `t.eq(set([1, 2]), set([4, 1, 3, 2]))`,
+		failed: true,
+		want:   []string{"3 missing from x", "4 missing from x"},
+	}, {
+		name:   "removed",
+		src:    `t.eq(set([3, 1, 2]), set([2]))`,
+		failed: true,
+		want:   []string{"1 missing from y", "3 missing from y"},
+	}, {
+		name:   "disjoint",
+		src:    `t.eq({"a": set(["b", "a"])}, {"a": set([1, "c"])})`,
+		failed: true,
+		want: []string{
+			`["a"]: "a" missing from y`,
+			`["a"]: "b" missing from y`,
+			`["a"]: 1 missing from x`,
+			`["a"]: "c" missing from x`,
+		},
+	}, {
+		name:   "mixed_types",
+		src:    `t.eq(set(), set(["c", 2, "a", 1]))`,
+		failed: true,
+		want:   []string{"1 missing from x", "2 missing from x", `"a" missing from x`, `"c" missing from x`},
+	}})
+}
+
+// point is a value with an uninformative string.
+type point struct {
+	X, Y   int
+	hidden int
+}
+
+func (p *point) String() string        { return "point" }
+func (p *point) Type() string          { return "point" }
+func (p *point) Freeze()               {}
+func (p *point) Truth() starlark.Bool  { return true }
+func (p *point) Hash() (uint32, error) { return uint32(p.X ^ p.Y), nil }
+func (p *point) CompareSameType(op syntax.Token, y starlark.Value, depth int) (bool, error) {
+	q := y.(*point)
+	eq := p.X == q.X && p.Y == q.Y
+	switch op {
+	case syntax.EQL:
+		return eq, nil
+	case syntax.NEQ:
+		return !eq, nil
+	}
+	return false, fmt.Errorf("%s %s %s not implemented", p.Type(), op, y.Type())
+}
+
+func TestWithReflectDiff(t *testing.T) {
+	points := WithLoad(func(_ *starlark.Thread, module string) (starlark.StringDict, error) {
+		return starlark.StringDict{
+			"a": &point{X: 1, Y: 2, hidden: 3},
+			"b": &point{X: 1, Y: 3},
+		}, nil
+	})
+	src := `
+load("points.star", "a", "b")
+t.eq(a, b)
+`
+	runRecordedTests(t, []recordedTest{{
+		name:   "default",
+		src:    src,
+		failed: true,
+		want:   []string{`"point" != "point"`},
+	}}, points)
+	runRecordedTests(t, []recordedTest{{
+		name:   "reflect",
+		src:    src,
+		failed: true,
+		want:   []string{`point{X: 1, Y: 2} != point{X: 1, Y: 3}`},
+	}}, points, WithReflectDiff())
+}
+
+func TestApproxEqList(t *testing.T) {
+	runRecordedTests(t, []recordedTest{{
+		name: "match",
+		src:  `t.approx_eq_list([1.0, 2, 3.0000000001], [1, 2.0, 3.0])`,
+	}, {
+		name: "abs",
+		src:  `t.approx_eq_list([0.0, 1.0], [0.05, 1.05], abs=0.1)`,
+	}, {
+		name:   "off_element",
+		src:    `t.approx_eq_list([1.0, 2.0, 3.0], [1.0, 2.5, 3.0])`,
+		failed: true,
+		want:   []string{"index 1: 2 != 2.5 (delta 0.5)"},
+	}, {
+		name:   "length",
+		src:    `t.approx_eq_list([1.0, 2.0], [1.0])`,
+		failed: true,
+		want:   []string{"length mismatch: 2 != 1"},
+	}})
+}
+
+func TestSameResult(t *testing.T) {
+	runRecordedTests(t, []recordedTest{{
+		name: "match",
+		src: `
+def f(x, y = 1):
+    return {"sum": x + y}
+def g(x, y = 1):
+    return {"sum": y + x}
+t.same_result(f, g, 1, y = 2)
+`,
+	}, {
+		name: "differ",
+		src: `
+def f(x):
+    return {"sum": x + 1}
+def g(x):
+    return {"sum": x + 2}
+t.same_result(f, g, 1)
+`,
+		failed: true,
+		want:   []string{`["sum"]: 2 != 3`},
+	}, {
+		name: "error",
+		src: `
+def f(x):
+    return x + 1
+def g(x):
+    return x + "1"
+t.same_result(f, g, 1)
+`,
+		failed: true,
+		want:   []string{"f returned: 2\ng failed: unknown binary op: int + string"},
+	}})
+}
+
+func TestLogValue(t *testing.T) {
+	r := runRecorded(t, `
+x = [1]
+x.append(x)
+t.log_value({"a": [1, (2, 3)], "b": struct(c = {}, d = x)})
+`)
+	want := `{
+    "a": [
+        1,
+        (
+            2,
+            3,
+        ),
+    ],
+    "b": struct(
+        c = {},
+        d = [
+            1,
+            [...],
+        ],
+    ),
+}`
+	if got := r.output(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestEqualJSONFile(t *testing.T) {
+	runRecordedTests(t, []recordedTest{{
+		name: "match",
+		src:  `t.equal_json_file({"name": "starlark", "tags": ["a", "b"], "nested": {"n": 1.5, "ok": True}}, "testdata/fixture.json")`,
+	}, {
+		name:   "mismatch",
+		src:    `t.equal_json_file({"name": "go", "tags": ["a"], "nested": {"n": 1.5}, "extra": None}, "testdata/fixture.json")`,
+		failed: true,
+		want: []string{
+			"testdata/fixture.json differs:",
+			"$.extra: unexpected",
+			`$.name: got "go", want "starlark"`,
+			"$.nested.ok: missing",
+			"$.tags: got 1 elements, want 2",
+		},
+	}, {
+		name: "numbers",
+		src:  `t.equal_json_file({"int": 1.0, "float": 1, "exp": 100, "small": 2.5e-1}, "testdata/numbers.json")`,
+	}, {
+		name:   "numbers_mismatch",
+		src:    `t.equal_json_file({"int": 1.5, "float": 1, "exp": 101, "small": 0.25}, "testdata/numbers.json")`,
+		failed: true,
+		want: []string{
+			"testdata/numbers.json differs:",
+			"$.exp: got 101, want 1e2",
+			"$.int: got 1.5, want 1",
+		},
+	}})
+}
+
+func TestEqualJSONFileUpdate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "update.json")
+	src := fmt.Sprintf(`t.equal_json_file({"a": [1, 2]}, %q)`, path)
+
+	t.Run("update", func(t *testing.T) {
+		defer SetUpdateGoldens(true)()
+		if r := runRecorded(t, src); r.failed {
+			t.Fatal(r.output())
+		}
+
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := string(b), "{\n  \"a\": [\n    1,\n    2\n  ]\n}\n"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+	t.Run("compare", func(t *testing.T) {
+		defer SetUpdateGoldens(false)()
+		if r := runRecorded(t, src); r.failed {
+			t.Fatal(r.output())
+		}
+		if err := os.WriteFile(path, []byte(`{"a": [1]}`), 0666); err != nil {
+			t.Fatal(err)
+		}
+		if r := runRecorded(t, src); !r.failed {
+			t.Error("expected comparison failure without update")
+		}
+	})
+
+	// Restoring the overrides applies the flag again.
+	restore := SetUpdateGoldens(true)
+	if !updateGoldens() {
+		t.Error("override not applied")
+	}
+	restore()
+	if updateOverride != nil {
+		t.Errorf("override %v not restored", *updateOverride)
+	}
+}
+
+func TestBytes(t *testing.T) {
+	runRecordedTests(t, []recordedTest{{
+		name: "eq",
+		src:  `t.bytes_eq(b"\x00\x01abc", b"\x00\x01abc")`,
+	}, {
+		name:   "not_eq",
+		src:    `t.bytes_eq(b"\x00\x01abc", b"\x00\x01abd")`,
+		failed: true,
+		want: []string{
+			"bytes differ at offset 4:",
+			"x: 00 01 61 62 63",
+			"y: 00 01 61 62 64",
+		},
+	}, {
+		name: "lt",
+		src:  `t.bytes_lt(b"abc", b"abd")`,
+	}, {
+		name:   "not_lt",
+		src:    `t.bytes_lt(b"abcd", b"abc")`,
+		failed: true,
+		want: []string{
+			"x is not less than y, bytes differ at offset 3:",
+			"x: 61 62 63 64",
+			"y: 61 62 63",
+		},
+	}, {
+		name: "type",
+		src:  `t.fails(lambda: t.bytes_eq("abc", b"abc"), "bytes_eq: for parameter x: got string, want bytes")`,
+	}})
+}
+
+func TestWithNoSkip(t *testing.T) {
+	r := runRecorded(t, `t.skip("flaky")`)
+	if !r.skipped || r.failed {
+		t.Errorf("got skipped %v, failed %v, want skipped", r.skipped, r.failed)
+	}
+
+	r = runRecorded(t, `t.skip("flaky")`, WithNoSkip())
+	if r.skipped || !r.failed {
+		t.Errorf("got skipped %v, failed %v, want failed", r.skipped, r.failed)
+	}
+	if got, want := r.output(), "skip not allowed: flaky"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	r = runRecorded(t, `t.skip()`, WithNoSkip())
+	if got, want := r.output(), "skip not allowed"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestFailed(t *testing.T) {
+	runRecordedTests(t, []recordedTest{{
+		name: "passing",
+		src:  `t.eq(t.failed(), False)`,
+	}, {
+		name: "soft_failure",
+		src: `
+def check():
+    t.eq(1, 2)
+    if t.failed():
+        print("skipping follow up")
+        return
+    t.eq(3, 4)
+
+check()
+`,
+		failed: true,
+		want:   []string{`"1" != "2"`, "skipping follow up"},
+	}})
+}
+
+func TestFatalf(t *testing.T) {
+	r := &recorder{TB: t}
+	thread := &starlark.Thread{
+		Name:  "fatalf.star",
+		Print: func(_ *starlark.Thread, msg string) { r.logs = append(r.logs, msg) },
+	}
+	globals := starlark.StringDict{
+		"fatalf": tmethod{starlark.None, "fatalf", r, tfatalf},
+	}
+	var returned bool
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		starlark.ExecFile(thread, thread.Name, `
+fatalf("got %d, want %s", 1, "2")
+fatalf("unreachable")
+`, globals)
+		returned = true
+	}()
+	<-exited
+	if returned {
+		t.Error("execution continued after fatalf")
+	}
+	if !r.failed {
+		t.Error("got passed, want failed")
+	}
+	if got, want := r.output(), "got 1, want 2"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	runRecordedTests(t, []recordedTest{{
+		name:   "no_args",
+		src:    `t.fatalf("100%% sure")`,
+		failed: true,
+		want:   []string{"100% sure"},
+	}, {
+		name: "bad_format",
+		src:  `t.fails(lambda: t.fatalf("%d", "x"), "fatalf: %d format requires integer")`,
+	}})
+}
+
+func TestWithMaxErrors(t *testing.T) {
+	r := &recorder{TB: t}
+	thread := &starlark.Thread{
+		Name:  "errors.star",
+		Print: func(_ *starlark.Thread, msg string) { r.logs = append(r.logs, msg) },
+	}
+	WithMaxErrors(2)(r, thread)
+	globals := starlark.StringDict{
+		"error": tmethod{starlark.None, "error", r, terror},
+	}
+
+	var returned bool
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		starlark.ExecFile(thread, thread.Name, `
+error("a")
+error("b")
+error("c")
+`, globals)
+		returned = true
+	}()
+	<-exited
+	if returned {
+		t.Error("execution continued after the maximum errors")
+	}
+	if got, want := r.output(), "a\nb\nstopping after 2 errors"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// Zero or less is no limit.
+	for _, n := range []int{0, -1} {
+		r := &recorder{TB: t}
+		if err := r.exec("errors.star", `t.error("a"); t.error("b")`, nil, []TestOption{WithMaxErrors(n)}); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := r.output(), "a\nb"; got != want {
+			t.Errorf("%d: got %q, want %q", n, got, want)
+		}
+	}
+}
+
+func TestNilTest(t *testing.T) {
+	for _, v := range []starlark.HasAttrs{NewTest(nil), NewBench(nil)} {
+		m, err := v.Attr("eq")
+		if err != nil {
+			t.Fatal(err)
+		}
+		thread := &starlark.Thread{Name: "nil.star"}
+		_, err = starlark.Call(thread, m, starlark.Tuple{starlark.None, starlark.None}, nil)
+		if err == nil {
+			t.Fatalf("%s: expected error", v.Type())
+		}
+		want := "eq: no test set on " + v.Type() + "; create it with a non-nil testing value"
+		if got := err.Error(); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestContainsAllAny(t *testing.T) {
+	runRecordedTests(t, []recordedTest{{
+		name: "all",
+		src:  `t.contains_all([1, 2, 3], [3, 1])`,
+	}, {
+		name:   "not_all",
+		src:    `t.contains_all([1, 2, 3], [1, 4, 5])`,
+		failed: true,
+		want:   []string{"[1, 2, 3] does not contain [4, 5]"},
+	}, {
+		name: "any",
+		src:  `t.contains_any({"a": 1, "b": 2}, ["c", "b"])`,
+	}, {
+		name:   "not_any",
+		src:    `t.contains_any(("a", "b"), ["c", "d"])`,
+		failed: true,
+		want:   []string{`("a", "b") contains none of ["c", "d"]`},
+	}})
+}
+
+func TestContainsSubstring(t *testing.T) {
+	runRecordedTests(t, []recordedTest{{
+		name: "present",
+		src:  `t.contains_substring("hello, world", "o, w")`,
+	}, {
+		name:   "absent",
+		src:    `t.contains_substring("hello", "world")`,
+		failed: true,
+		want:   []string{`"hello" does not contain substring "world"`},
+	}, {
+		name: "empty_needle",
+		src:  `t.contains_substring("hello", ""); t.contains_substring("", "")`,
+	}})
+}
+
+func TestEqRepr(t *testing.T) {
+	runRecordedTests(t, []recordedTest{{
+		name: "match",
+		src:  `t.eq_repr({"a": [1, 2.0, None]}, '{"a": [1, 2.0, None]}')`,
+	}, {
+		name:   "mismatch",
+		src:    `t.eq_repr({"a": [1, 2]}, '{"a": [1, 2.0]}')`,
+		failed: true,
+		want: []string{
+			"repr differs at position 11:",
+			` got: {"a": [1, 2]}`,
+			`want: {"a": [1, 2.0]}`,
+			"                 ^",
+		},
+	}})
+}
+
+func TestPanicsWithType(t *testing.T) {
+	panics := WithLoad(func(_ *starlark.Thread, module string) (starlark.StringDict, error) {
+		return starlark.StringDict{
+			"panic": starlark.NewBuiltin("panic", func(_ *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+				switch v := args[0].(type) {
+				case starlark.String:
+					panic(string(v))
+				case starlark.Int:
+					i, _ := v.Int64()
+					panic(int(i))
+				case starlark.NoneType:
+					var m map[string]int
+					m["nil"]++
+				}
+				panic(errors.New(args[0].String()))
+			}),
+		}, nil
+	})
+	runRecordedTests(t, []recordedTest{{
+		name: "string",
+		src:  `load("panics.star", "panic"); t.panics_with_type(lambda: panic("boom"), "string")`,
+	}, {
+		name: "int",
+		src:  `load("panics.star", "panic"); t.panics_with_type(lambda: panic(1), "int")`,
+	}, {
+		name: "error",
+		src:  `load("panics.star", "panic"); t.panics_with_type(lambda: panic(1.5), "*errors.errorString")`,
+	}, {
+		name: "runtime",
+		src:  `load("panics.star", "panic"); t.panics_with_type(lambda: panic(None), "runtime.plainError")`,
+	}, {
+		name:   "mismatch",
+		src:    `load("panics.star", "panic"); t.panics_with_type(lambda: panic("boom"), "int")`,
+		failed: true,
+		want:   []string{"panic of type string, want int: boom"},
+	}, {
+		name:   "no_panic",
+		src:    `load("panics.star", "panic"); t.panics_with_type(lambda: None, "string")`,
+		failed: true,
+		want:   []string{"did not panic (want panic of type string)"},
+	}, {
+		name:   "error_without_panic",
+		src:    `load("panics.star", "panic"); t.panics_with_type(lambda: 1 // 0, "string")`,
+		failed: true,
+		want:   []string{"failed without panic (want panic of type string): floored division by zero"},
+	}}, panics)
+}
+
+func TestChildThreadOptions(t *testing.T) {
+	panics := WithLoad(func(_ *starlark.Thread, module string) (starlark.StringDict, error) {
+		return starlark.StringDict{
+			"panic": starlark.NewBuiltin("panic", func(_ *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+				panic(args[0].String())
+			}),
+		}, nil
+	})
+	redact := WithRedactor(func(s string) string {
+		return strings.ReplaceAll(s, "hunter2", "***")
+	})
+	runRecordedTests(t, []recordedTest{{
+		name:   "with_timeout",
+		src:    `t.with_timeout("1m", lambda: [t.eq(1.0, 1.0 + 1e-12), t.eq("hunter2", "x")])`,
+		failed: true,
+		want:   []string{`"\"***\"" != "\"x\""`},
+	}, {
+		name:   "panics_with_type",
+		src:    `load("panics.star", "panic"); t.panics_with_type(lambda: [t.eq(1.0, 1.0 + 1e-12), t.eq("hunter2", "x"), panic("boom")], "string")`,
+		failed: true,
+		want:   []string{`"\"***\"" != "\"x\""`},
+	}}, panics, redact, WithFloatTolerance(1e-9, 0))
+}
+
+func TestEqIgnoring(t *testing.T) {
+	runRecordedTests(t, []recordedTest{{
+		name: "top_level",
+		src:  `t.eq_ignoring({"id": 1, "name": "a"}, {"id": 2, "name": "a"}, ["id"])`,
+	}, {
+		name: "nested",
+		src: `t.eq_ignoring(
+    {"user": struct(id = 1, name = "a"), "at": 10},
+    {"user": struct(id = 2, name = "a"), "at": 20},
+    ["user.id", "at"],
+)`,
+	}, {
+		name:   "remaining",
+		src:    `t.eq_ignoring(struct(id = 1, user = {"id": 1, "name": "a"}), struct(id = 2, user = {"id": 2, "name": "b"}), ["id", "user.id"])`,
+		failed: true,
+		want:   []string{`user["name"]: "a" != "b"`},
+	}})
+}
+
+func TestEmpty(t *testing.T) {
+	runRecordedTests(t, []recordedTest{{
+		name: "empty",
+		src:  `t.empty([]); t.empty(""); t.empty({})`,
+	}, {
+		name: "not_empty",
+		src:  `t.not_empty([1]); t.not_empty("a"); t.not_empty({"a": 1})`,
+	}, {
+		name:   "empty_fails",
+		src:    `t.empty([1, 2]); t.empty("abc"); t.empty({"a": 1})`,
+		failed: true,
+		want: []string{
+			"expected empty, got length 2: [1, 2]",
+			`expected empty, got length 3: "abc"`,
+			`expected empty, got length 1: {"a": 1}`,
+		},
+	}, {
+		name:   "not_empty_fails",
+		src:    `t.not_empty([]); t.not_empty(""); t.not_empty({})`,
+		failed: true,
+		want: []string{
+			"expected not empty, got length 0: []",
+			`expected not empty, got length 0: ""`,
+			"expected not empty, got length 0: {}",
+		},
+	}, {
+		name:   "truncated",
+		src:    `t.empty(list(range(30)))`,
+		failed: true,
+		want:   []string{"expected empty, got length 30: [0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 1..."},
+	}, {
+		name: "not_sequence",
+		src:  `t.fails(lambda: t.empty(1), "empty: got int, want a value with length")`,
+	}})
+}
+
+func TestWithTimeout(t *testing.T) {
+	runRecordedTests(t, []recordedTest{{
+		name: "fast",
+		src:  `t.with_timeout("1m", lambda: print("done"))`,
+		want: []string{"done"},
+	}, {
+		name: "slow",
+		src: `
+def slow():
+    for _ in range(1 << 62):
+        pass
+t.with_timeout("10ms", slow)
+`,
+		failed: true,
+		want:   []string{"timed out after 10ms"},
+	}, {
+		name: "error",
+		src:  `t.fails(lambda: t.with_timeout("1m", lambda: 1 // 0), "division by zero")`,
+	}, {
+		name: "duration",
+		src:  `t.with_timeout(time.minute, lambda: print("done"))`,
+		want: []string{"done"},
+	}, {
+		name:   "fatal",
+		src:    `t.with_timeout("1m", lambda: t.fatal("stop")); t.error("unreachable")`,
+		failed: true,
+		want:   []string{"stop"},
+	}, {
+		name:   "require",
+		src:    `t.with_timeout("1m", lambda: t.require.eq(1, 2)); t.error("unreachable")`,
+		failed: true,
+		want:   []string{`"1" != "2"`},
+	}, {
+		name: "skip",
+		src:  `t.with_timeout("1m", lambda: t.skip("later")); t.error("unreachable")`,
+		want: []string{"later"},
+	}})
+}
+
+func TestMatch(t *testing.T) {
+	runRecordedTests(t, []recordedTest{{
+		name: "all_match",
+		src:  `t.all_match(["a1", "b2"], "[a-z][0-9]"); t.all_match([], "x")`,
+	}, {
+		name:   "all_match_partial",
+		src:    `t.all_match(["a1", "b", "c"], "[0-9]")`,
+		failed: true,
+		want:   []string{`element 1 "b" does not match "[0-9]"`},
+	}, {
+		name: "any_match",
+		src:  `t.any_match(["a", "b2"], "[0-9]")`,
+	}, {
+		name:   "any_match_none",
+		src:    `t.any_match(["a", "b"], "[0-9]")`,
+		failed: true,
+		want:   []string{`none of 2 elements match "[0-9]"`},
+	}, {
+		name:   "all_match_none",
+		src:    `t.all_match(["a", "b"], "[0-9]")`,
+		failed: true,
+		want:   []string{`element 0 "a" does not match "[0-9]"`},
+	}, {
+		name: "invalid",
+		src:  `t.fails(lambda: t.all_match(["a"], "("), "all_match: error parsing regexp")`,
+	}, {
+		name: "not_string",
+		src:  `t.fails(lambda: t.any_match(["a", 1], "a"), "any_match: got int at index 1, want string")`,
+	}})
+}
+
+func TestDiffContext(t *testing.T) {
+	src := `
+x = "\n".join([str(i) for i in range(1, 21)])
+t.eq(x, x.replace("10", "ten").replace("12", "twelve"))
+`
+	tests := []struct {
+		name string
+		opts []TestOption
+		want string
+	}{{
+		name: "default",
+		want: `@@ -7,9 +7,9 @@
+ 7
+ 8
+ 9
+-10
++ten
+ 11
+-12
++twelve
+ 13
+ 14
+ 15`,
+	}, {
+		name: "one",
+		opts: []TestOption{WithDiffContext(1)},
+		want: `@@ -9,5 +9,5 @@
+ 9
+-10
++ten
+ 11
+-12
++twelve
+ 13`,
+	}, {
+		name: "split",
+		opts: []TestOption{WithDiffContext(0)},
+		want: `@@ -10,1 +10,1 @@
+-10
++ten
+@@ -12,1 +12,1 @@
+-12
++twelve`,
+	}, {
+		name: "negative",
+		opts: []TestOption{WithDiffContext(-1)},
+		want: `@@ -10,1 +10,1 @@
+-10
++ten
+@@ -12,1 +12,1 @@
+-12
++twelve`,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := runRecorded(t, src, tt.opts...)
+			if !r.failed {
+				t.Fatal("expected failure")
+			}
+			if got := r.output(); got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLineDiffLarge(t *testing.T) {
+	x := strings.Repeat("a\n", 2000)
+	y := x + "b"
+	if got, want := lineDiff(x, y, 3), fmt.Sprintf("%q != %q", x, y); got != want {
+		t.Errorf("got %.40q, want the quoted texts", got)
+	}
+}
+
+func TestTruthy(t *testing.T) {
+	runRecordedTests(t, []recordedTest{{
+		name: "truthy",
+		src:  `t.is_truthy([1]); t.is_truthy("a"); t.is_truthy(1)`,
+	}, {
+		name: "falsy",
+		src:  `t.is_falsy([]); t.is_falsy(""); t.is_falsy(0); t.is_falsy(None)`,
+	}, {
+		name:   "truthy_fails",
+		src:    `t.is_truthy([]); t.is_truthy({}); t.is_truthy(None)`,
+		failed: true,
+		want: []string{
+			"expected truthy value, got []",
+			"expected truthy value, got {}",
+			"expected truthy value, got None",
+		},
+	}, {
+		name:   "falsy_fails",
+		src:    `t.is_falsy([1, 2]); t.is_falsy("abc")`,
+		failed: true,
+		want: []string{
+			"expected falsy value, got [1, 2]",
+			`expected falsy value, got "abc"`,
+		},
+	}})
+}
+
+func TestAssertTypeError(t *testing.T) {
+	runRecordedTests(t, []recordedTest{{
+		name: "type_errors",
+		src: `
+t.assert_type_error(len, 1)
+t.assert_type_error(lambda x: {x: 1}, [])
+t.assert_type_error(lambda x, y: x + y, 1, "a", pattern = "int \\+ string")
+t.assert_type_error("".join, [1])
+`,
+	}, {
+		name:   "not_type_error",
+		src:    `t.assert_type_error(lambda: 1 // 0)`,
+		failed: true,
+		want:   []string{"error is not a type error: floored division by zero"},
+	}, {
+		name:   "succeeds",
+		src:    `t.assert_type_error(len, [])`,
+		failed: true,
+		want:   []string{"evaluation succeeded unexpectedly (want type error)"},
+	}, {
+		name:   "pattern",
+		src:    `t.assert_type_error(len, 1, pattern = "string")`,
+		failed: true,
+		want:   []string{"regular expression (string) did not match type error (len: value of type int has no len)"},
+	}})
+}
+
+func TestBigInt(t *testing.T) {
+	runRecordedTests(t, []recordedTest{{
+		name: "equal",
+		src:  `t.eq(1 << 100, 1267650600228229401496703205376); t.ne(1 << 100, (1 << 100) + 1); t.lt(-(1 << 70), 1 << 70)`,
+	}, {
+		name:   "not_equal",
+		src:    `t.eq(1 << 100, (1 << 100) + 1)`,
+		failed: true,
+		want:   []string{`"1267650600228229401496703205376" != "1267650600228229401496703205377"`},
+	}, {
+		name:   "dict",
+		src:    `t.eq({"n": 1 << 64}, {"n": -(1 << 64)})`,
+		failed: true,
+		want:   []string{`["n"]: 18446744073709551616 != -18446744073709551616`},
+	}, {
+		name:   "not_less",
+		src:    `t.lt(1 << 80, 1 << 70)`,
+		failed: true,
+		want:   []string{"1208925819614629174706176 is not less than 1180591620717411303424"},
+	}})
+}
+
+func TestEqPairs(t *testing.T) {
+	runRecordedTests(t, []recordedTest{{
+		name: "reordered",
+		src:  `t.eq_pairs([("a", 1), ("b", 2), ("a", 1)], [("b", 2), ("a", 1), ("a", 1)])`,
+	}, {
+		name:   "missing",
+		src:    `t.eq_pairs([("a", 1), ("b", 2), ("c", 3)], [("c", 3), ("a", 1)])`,
+		failed: true,
+		want:   []string{`("b", 2) missing from y`},
+	}, {
+		name:   "extra",
+		src:    `t.eq_pairs([("a", 1)], [("a", 1), ("z", 0), ("a", 1), ("a", 2)])`,
+		failed: true,
+		want: []string{
+			`("a", 1) missing from x`,
+			`("a", 2) missing from x`,
+			`("z", 0) missing from x`,
+		},
+	}, {
+		name: "not_pair",
+		src:  `t.fails(lambda: t.eq_pairs([("a", 1)], [("a",)]), "eq_pairs: for parameter y: index 0: got \\(\"a\",\\), want \\(key, value\\) pair")`,
+	}})
+}
+
+func TestWithinRange(t *testing.T) {
+	runRecordedTests(t, []recordedTest{{
+		name: "in_range",
+		src:  `t.within_range(2, 1, 3); t.within_range(1.5, 1, 2.0); t.within_range(1, 1, 3); t.within_range(3.0, 1, 3)`,
+	}, {
+		name: "strict",
+		src:  `t.within_range(2, 1, 3, inclusive = False); t.within_range(1.5, 1, 2, inclusive = False)`,
+	}, {
+		name:   "below",
+		src:    `t.within_range(0, 1, 3); t.within_range(0.5, 1.0, 3.0)`,
+		failed: true,
+		want: []string{
+			"0 is less than lower bound 1",
+			"0.5 is less than lower bound 1.0",
+		},
+	}, {
+		name:   "above",
+		src:    `t.within_range(4, 1, 3); t.within_range(3.5, 1, 3)`,
+		failed: true,
+		want: []string{
+			"4 is greater than upper bound 3",
+			"3.5 is greater than upper bound 3",
+		},
+	}, {
+		name:   "boundary",
+		src:    `t.within_range(1, 1, 3, inclusive = False); t.within_range(3.0, 1, 3, inclusive = False)`,
+		failed: true,
+		want: []string{
+			"1 is not greater than lower bound 1",
+			"3.0 is not less than upper bound 3",
+		},
+	}, {
+		name: "types",
+		src:  `t.fails(lambda: t.within_range("a", 1, 3), "not implemented")`,
+	}})
+}
+
+func TestEqualTypes(t *testing.T) {
+	runRecordedTests(t, []recordedTest{{
+		name: "numeric",
+		src:  `t.eq(1, 1.0); t.ne(1, "1")`,
+	}, {
+		name:   "string_int",
+		src:    `t.eq("1", 1)`,
+		failed: true,
+		want:   []string{`got string "1", want int 1`},
+	}, {
+		name:   "list_tuple",
+		src:    `t.eq([1, 2], (1, 2)); t.eq(None, False)`,
+		failed: true,
+		want: []string{
+			"got list [1, 2], want tuple (1, 2)",
+			"got NoneType None, want bool False",
+		},
+	}, {
+		name:   "ne_numeric",
+		src:    `t.ne(1, 1.0)`,
+		failed: true,
+		want:   []string{"got int 1, equal to float 1.0"},
+	}})
+}
+
+func TestIsSame(t *testing.T) {
+	runRecordedTests(t, []recordedTest{{
+		name: "same",
+		src: `
+def f():
+    pass
+g = f
+l = [1]
+t.is_same(f, g); t.is_same(len, len); t.is_same(l, l); t.is_same(1, 1); t.is_same("a", "a")
+d = {"a": f}
+t.is_same(d["a"], f)
+tup = (1, [2])
+t.is_same(tup, tup)
+`,
+	}, {
+		name: "different",
+		src: `
+def make():
+    def f():
+        pass
+    return f
+t.is_same(make(), make())
+t.is_same([1], [1])
+t.is_same(len, str)
+t.is_same((1, 2), (1, 2))
+`,
+		failed: true,
+		want: []string{
+			"expected the same reference, got function <function f> and function <function f>",
+			"expected the same reference, got list [1] and list [1]",
+			"expected the same reference, got builtin_function_or_method <built-in function len> and builtin_function_or_method <built-in function str>",
+			"expected the same reference, got tuple (1, 2) and tuple (1, 2)",
+		},
+	}})
+}
+
+func TestIsSorted(t *testing.T) {
+	runRecordedTests(t, []recordedTest{{
+		name: "sorted",
+		src:  `t.is_sorted([1, 2, 2, 3]); t.is_sorted(["a", "b"]); t.is_sorted([1, 1.5, 2])`,
+	}, {
+		name: "single",
+		src:  `t.is_sorted([1]); t.is_sorted([]); t.is_sorted([1], reverse = True)`,
+	}, {
+		name: "reverse",
+		src:  `t.is_sorted([3, 2, 2, 1], reverse = True)`,
+	}, {
+		name:   "unsorted",
+		src:    `t.is_sorted([1, 3, 2, 0]); t.is_sorted([1, 2, 3], reverse = True)`,
+		failed: true,
+		want: []string{
+			"elements 1 and 2 are out of order: 3 > 2",
+			"elements 0 and 1 are out of order: 1 < 2",
+		},
+	}, {
+		name: "incomparable",
+		src:  `t.fails(lambda: t.is_sorted([1, "a"]), "is_sorted: index 1: .*not implemented")`,
+	}})
+}
+
+func TestEqText(t *testing.T) {
+	runRecordedTests(t, []recordedTest{{
+		name: "crlf",
+		src:  `t.eq_text("a\r\nb\r\n", "a\nb\n"); t.eq_text("a\r\nb", "a\nb", strip_trailing = False)`,
+	}, {
+		name: "trailing",
+		src:  `t.eq_text("a  \nb\t\n", "a\nb\n")`,
+	}, {
+		name:   "strict_trailing",
+		src:    `t.eq_text("a  \nb", "a\nb", strip_trailing = False)`,
+		failed: true,
+		want:   []string{"@@ -1,2 +1,2 @@\n-a  \n+a\n b"},
+	}, {
+		name:   "differs",
+		src:    `t.eq_text("a\r\nb\r\nc", "a\nc\n")`,
+		failed: true,
+		want:   []string{"@@ -1,3 +1,3 @@\n a\n-b\n c\n+"},
+	}})
+}
+
+func TestApprox(t *testing.T) {
+	runRecordedTests(t, []recordedTest{{
+		name: "finite",
+		src:  `t.approx(1.0, 1.0 + 1e-12); t.approx(1, 1.05, rel = 0.1); t.approx(0.0, 1e-3, abs = 1e-2)`,
+	}, {
+		name:   "not_close",
+		src:    `t.approx(1.0, 1.5)`,
+		failed: true,
+		want:   []string{"1 != 1.5 (delta 0.5)"},
+	}, {
+		name: "inf",
+		src:  `inf = float("inf"); t.approx(inf, inf); t.approx(-inf, -inf)`,
+	}, {
+		name:   "inf_fails",
+		src:    `inf = float("inf"); t.approx(inf, 1e308); t.approx(1.0, -inf, abs = 1e300); t.approx(inf, -inf)`,
+		failed: true,
+		want: []string{
+			"+Inf != 1e+308",
+			"1 != -Inf",
+			"+Inf != -Inf",
+		},
+	}, {
+		name:   "nan",
+		src:    `nan = float("nan"); inf = float("inf"); t.approx(nan, nan); t.approx(nan, 1.0); t.approx(1.0, nan); t.approx(inf, nan)`,
+		failed: true,
+		want: []string{
+			"NaN is not approximately equal: NaN, NaN",
+			"NaN is not approximately equal: NaN, 1",
+			"NaN is not approximately equal: 1, NaN",
+			"NaN is not approximately equal: +Inf, NaN",
+		},
+	}, {
+		name:   "list_inf",
+		src:    `t.approx_eq_list([float("inf")], [1.0])`,
+		failed: true,
+		want:   []string{"index 0: +Inf != 1 (delta +Inf)"},
+	}})
+}
+
+func TestDurationApprox(t *testing.T) {
+	runRecordedTests(t, []recordedTest{{
+		name: "within",
+		src: `
+a = time.parse_duration("1s")
+t.duration_approx(a, a + time.parse_duration("5ms"), time.parse_duration("10ms"))
+t.duration_approx(a, a - time.parse_duration("10ms"), "10ms")
+t.duration_approx("2s", "2s", "0s")
+`,
+	}, {
+		name:   "outside",
+		src:    `t.duration_approx(time.parse_duration("1s"), time.parse_duration("1.5s"), "100ms")`,
+		failed: true,
+		want:   []string{"1s != 1.5s (delta 500ms, tolerance 100ms)"},
+	}, {
+		name: "bad_type",
+		src:  `t.fails(lambda: t.duration_approx(1, 1, 0), "duration_approx: for parameter x")`,
+	}})
+}
+
+func TestBitsEq(t *testing.T) {
+	runRecordedTests(t, []recordedTest{{
+		name: "equal",
+		src:  `nan = float("nan"); t.bits_eq(1.5, 1.5); t.bits_eq(-0.0, -0.0); t.bits_eq(nan, nan)`,
+	}, {
+		name:   "signed_zero",
+		src:    `t.eq(0.0, -0.0); t.bits_eq(0.0, -0.0)`,
+		failed: true,
+		want:   []string{"0x0000000000000000 != 0x8000000000000000 (0 != -0)"},
+	}, {
+		name:   "nan_bits",
+		src:    `nan = float("nan"); t.bits_eq(nan, -nan)`,
+		failed: true,
+		want:   []string{"0x7ff8000000000001 != 0xfff8000000000001 (NaN != NaN)"},
+	}, {
+		name: "not_float",
+		src:  `t.fails(lambda: t.bits_eq("a", 1.0), "bits_eq: for parameter x: got string, want float")`,
+	}})
+}
+
+func TestWithStackTraces(t *testing.T) {
+	src := `
+def check_positive(x):
+    t.true(x > 0, "not positive")
+
+def check_all(xs):
+    for x in xs:
+        check_positive(x)
+
+check_all([1, -1, -2])
+`
+	r := runRecorded(t, src, WithStackTraces())
+	if !r.failed {
+		t.Fatal("expected failure")
+	}
+	trace := `Traceback (most recent call last):
+  recorded.star:9:10: in <toplevel>
+  recorded.star:7:23: in check_all
+  recorded.star:3:11: in check_positive`
+	want := []string{"not positive", trace, "not positive", trace}
+	if got := r.output(); got != strings.Join(want, "\n") {
+		t.Errorf("got:\n%s\nwant:\n%s", got, strings.Join(want, "\n"))
+	}
+
+	if r := runRecorded(t, src); r.output() != "not positive\nnot positive" {
+		t.Errorf("got %q without option", r.output())
+	}
+}
+
+func TestWithFloatTolerance(t *testing.T) {
+	runRecordedTests(t, []recordedTest{{
+		name: "nested",
+		src: `
+t.eq(0.1 + 0.2, 0.3)
+t.eq([0.1 + 0.2, (1.0, 2)], [0.3, (1.0 + 1e-12, 2)])
+t.eq({"a": [0.1 + 0.2]}, {"a": [0.3]})
+t.eq(struct(x = 1.0 / 3), struct(x = 0.333333333333))
+t.eq("ab", "ab")
+`,
+	}, {
+		name:   "outside",
+		src:    `t.eq({"a": [1.0, 2.0]}, {"a": [1.0, 2.5]}); t.eq([1.0], [1.0, 2.0])`,
+		failed: true,
+		want: []string{
+			`["a"][1]: 2.0 != 2.5 (delta 0.5)`,
+			"[1.0] != [1.0, 2.0]",
+		},
+	}, {
+		name:   "types",
+		src:    `t.eq(["1"], [1.0])`,
+		failed: true,
+		want:   []string{`[0]: "1" != 1.0`},
+	}, {
+		name:   "type_mismatch",
+		src:    `t.eq("1", 1)`,
+		failed: true,
+		want:   []string{`got string "1", want int 1`},
+	}, {
+		name:   "ne",
+		src:    `t.ne(0.1 + 0.2, 0.3); t.ne(1.0, 1.5)`,
+		failed: true,
+		want:   []string{`"0.30000000000000004" != "0.3"`},
+	}}, WithFloatTolerance(1e-9, 0))
+
+	runRecordedTests(t, []recordedTest{{
+		name:   "side_by_side",
+		src:    `t.eq("1", 1)`,
+		failed: true,
+		want:   []string{"got string | want int\n\"1\"        | 1"},
+	}}, WithFloatTolerance(1e-9, 0), WithSideBySideDiff())
+}
+
+func TestWithMaxDiffs(t *testing.T) {
+	runRecordedTests(t, []recordedTest{{
+		name:   "capped",
+		src:    `t.eq({"a": 1, "b": 2, "c": 3, "d": 4}, {"a": 0, "b": 0, "c": 0, "d": 0})`,
+		failed: true,
+		want: []string{
+			"[\"a\"]: 1 != 0\n[\"b\"]: 2 != 0\nshowing 2 of 4 differences",
+		},
+	}, {
+		name:   "under",
+		src:    `t.eq({"a": 1, "b": 2}, {"a": 0, "b": 0})`,
+		failed: true,
+		want:   []string{"[\"a\"]: 1 != 0\n[\"b\"]: 2 != 0"},
+	}}, WithMaxDiffs(2))
+}
+
+func TestWithSideBySideDiff(t *testing.T) {
+	runRecordedTests(t, []recordedTest{{
+		name:   "types",
+		src:    `t.eq(["a", {"k": 1}], ("a", 2, 3))`,
+		failed: true,
+		want: []string{strings.Join([]string{
+			`got list    | want tuple`,
+			`[           | (`,
+			`  "a",      |   "a",`,
+			`  {"k": 1}, |   2,`,
+			`]           |   3,`,
+			`            | )`,
+		}, "\n")},
+	}, {
+		name:   "scalars",
+		src:    `t.eq("1", 1)`,
+		failed: true,
+		want:   []string{"got string | want int\n\"1\"        | 1"},
+	}, {
+		name:   "lists",
+		src:    `t.eq([1, 2], [1])`,
+		failed: true,
+		want:   []string{"got list | want list\n[        | [\n  1,     |   1,\n  2,     | ]\n]"},
+	}}, WithSideBySideDiff())
+}
+
+func TestWithRedactor(t *testing.T) {
+	token := regexp.MustCompile(`tok_[a-z0-9]+`)
+	redact := WithRedactor(func(s string) string {
+		return token.ReplaceAllString(s, "tok_REDACTED")
+	})
+	runRecordedTests(t, []recordedTest{{
+		name:   "eq",
+		src:    `t.eq({"auth": "tok_abc123"}, {"auth": "tok_def456"})`,
+		failed: true,
+		want:   []string{`["auth"]: "tok_REDACTED" != "tok_REDACTED"`},
+	}, {
+		name:   "ne",
+		src:    `t.ne("tok_abc123", "tok_abc123")`,
+		failed: true,
+		want:   []string{`"\"tok_REDACTED\"" != "\"tok_REDACTED\""`},
+	}, {
+		name:   "lt",
+		src:    `t.lt("tok_b", "tok_a")`,
+		failed: true,
+		want:   []string{`"tok_REDACTED" is not less than "tok_REDACTED"`},
+	}, {
+		name:   "text",
+		src:    `t.eq("key: tok_abc\nok", "key: tok_def\nok")`,
+		failed: true,
+		want:   []string{"@@ -1,2 +1,2 @@\n-key: tok_REDACTED\n+key: tok_REDACTED\n ok"},
+	}}, redact)
+}
+
+func TestRepr(t *testing.T) {
+	for _, tt := range []struct {
+		x, y string // equal values built in a different order
+		want string
+	}{{
+		x:    `{"b": 1, "a": [{"d": 2, "c": 3}]}`,
+		y:    `{"a": [{"c": 3, "d": 2}], "b": 1}`,
+		want: `{"a": [{"c": 3, "d": 2}], "b": 1}`,
+	}, {
+		x:    `set([3, 1, 2])`,
+		y:    `set([2, 3, 1])`,
+		want: `set([1, 2, 3])`,
+	}, {
+		x:    `struct(b = {"y": 1, "x": 2}, a = (1,))`,
+		y:    `struct(a = (1,), b = {"x": 2, "y": 1})`,
+		want: `struct(a = (1,), b = {"x": 2, "y": 1})`,
+	}, {
+		x:    `{1: "int", "s": "string", (1, 2): "tuple"}`,
+		y:    `{(1, 2): "tuple", "s": "string", 1: "int"}`,
+		want: `{1: "int", "s": "string", (1, 2): "tuple"}`,
+	}, {
+		x:    `[None, True, 1.5, "a"]`,
+		y:    `[None, True, 1.5, "a"]`,
+		want: `[None, True, 1.5, "a"]`,
+	}} {
+		var got []string
+		for _, src := range []string{tt.x, tt.y} {
+			thread := &starlark.Thread{Name: "repr.star"}
+			v, err := starlark.Eval(thread, thread.Name, src, starlark.StringDict{
+				"struct": starlark.NewBuiltin("struct", starlarkstruct.Make),
+				"set":    starlark.Universe["set"],
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			s, err := Repr(v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, s)
+		}
+		if got[0] != tt.want || got[1] != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.x, got, tt.want)
+		}
+	}
+
+	cycle := starlark.NewList(nil)
+	cycle.Append(cycle)
+	d := starlark.NewDict(1)
+	d.SetKey(starlark.String("self"), d)
+	for v, want := range map[starlark.Value]string{
+		cycle: "[[...]]",
+		d:     `{"self": {...}}`,
+	} {
+		if got, err := Repr(v); err != nil || got != want {
+			t.Errorf("got %q, %v, want %q", got, err, want)
+		}
+	}
+}
+
+func TestReprFrozen(t *testing.T) {
+	list := starlark.NewList([]starlark.Value{starlark.MakeInt(1)})
+	if got, _ := Repr(list); got != "[1]" {
+		t.Fatalf("got %q", got)
+	}
+	list.Append(starlark.MakeInt(2))
+	if got, _ := Repr(list); got != "[1, 2]" {
+		t.Errorf("got %q after append, want [1, 2]", got)
+	}
+
+	list.Freeze()
+	if got, _ := Repr(list); got != "[1, 2]" {
+		t.Errorf("got %q after freeze, want [1, 2]", got)
+	}
+}
+
+func BenchmarkRepr(b *testing.B) {
+	newDict := func() *starlark.Dict {
+		d := starlark.NewDict(1000)
+		for i := 0; i < 1000; i++ {
+			d.SetKey(starlark.MakeInt(i), starlark.NewList([]starlark.Value{starlark.String(fmt.Sprint(i))}))
+		}
+		return d
+	}
+	mutable, frozen := newDict(), newDict()
+	frozen.Freeze()
+
+	for _, bb := range []struct {
+		name string
+		v    starlark.Value
+	}{{"mutable", mutable}, {"frozen", frozen}} {
+		b.Run(bb.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := Repr(bb.v); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestCollapsedDiff(t *testing.T) {
+	runRecordedTests(t, []recordedTest{{
+		name:   "middle",
+		src:    `x = list(range(100)); y = list(x); y[50] = -1; t.eq(x, y)`,
+		failed: true,
+		want:   []string{"[… (47 unchanged) …, 47, 48, 49, 50, 51, 52, 53, … (46 unchanged) …] != [… (47 unchanged) …, 47, 48, 49, -1, 51, 52, 53, … (46 unchanged) …]"},
+	}, {
+		name:   "appended",
+		src:    `t.eq((1, 2, 3, 4, 5, 6), (1, 2, 3, 4, 5, 6, 7))`,
+		failed: true,
+		want:   []string{"(… (3 unchanged) …, 4, 5, 6) != (… (3 unchanged) …, 4, 5, 6, 7)"},
+	}, {
+		name:   "short",
+		src:    `t.eq([1, 2, 3, 4], [1, 2, 3, 4, 5])`,
+		failed: true,
+		want:   []string{`"[1, 2, 3, 4]" != "[1, 2, 3, 4, 5]"`},
+	}})
+
+	runRecordedTests(t, []recordedTest{{
+		name:   "context",
+		src:    `t.eq([1, 2, 3, 4, 5], [1, 2, 3, 4, 6])`,
+		failed: true,
+		want:   []string{"[… (3 unchanged) …, 4, 5] != [… (3 unchanged) …, 4, 6]"},
+	}}, WithDiffContext(1))
+
+	// A negative context is treated as none.
+	runRecordedTests(t, []recordedTest{{
+		name:   "negative_context",
+		src:    `t.eq([1, 2, 3, 4, 5], [1, 2, 3, 4, 6])`,
+		failed: true,
+		want:   []string{"[… (4 unchanged) …, 5] != [… (4 unchanged) …, 6]"},
+	}}, WithDiffContext(-2))
+}
+
+func TestEqIter(t *testing.T) {
+	runRecordedTests(t, []recordedTest{{
+		name: "equal",
+		src:  `t.eq_iter(range(100000), [i for i in range(100000)])`,
+	}, {
+		name:   "index",
+		src:    `t.eq_iter(range(100000), [i if i != 76543 else -1 for i in range(100000)])`,
+		failed: true,
+		want:   []string{"index 76543: 76543 != -1"},
+	}, {
+		name:   "x_shorter",
+		src:    `t.eq_iter(range(3), range(5))`,
+		failed: true,
+		want:   []string{"x ended at index 3, y continues with 3"},
+	}, {
+		name:   "y_shorter",
+		src:    `t.eq_iter(("a", "b"), ["a"])`,
+		failed: true,
+		want:   []string{`y ended at index 1, x continues with "b"`},
+	}})
+}
+
+func TestEqualTime(t *testing.T) {
+	runRecordedTests(t, []recordedTest{{
+		name: "equal",
+		src:  `t.eq(time.from_timestamp(0), time.from_timestamp(0))`,
+	}, {
+		name: "times",
+		src: `t.eq(
+    time.parse_time("2020-01-01T00:00:01.5Z"),
+    time.parse_time("2020-01-01T00:00:00Z"),
+)`,
+		failed: true,
+		want:   []string{"times differ by 1.5s: 2020-01-01 00:00:01.5 +0000 UTC != 2020-01-01 00:00:00 +0000 UTC"},
+	}, {
+		name:   "durations",
+		src:    `t.eq(time.parse_duration("1s"), time.parse_duration("1m"))`,
+		failed: true,
+		want:   []string{"durations differ by 59s: 1s != 1m0s"},
+	}, {
+		name: "nested",
+		src: `t.eq(
+    {"at": time.parse_time("2020-01-01T00:00:00Z")},
+    {"at": time.parse_time("2020-01-01T00:00:02Z")},
+)`,
+		failed: true,
+		want:   []string{`["at"]: times differ by 2s: 2020-01-01 00:00:00 +0000 UTC != 2020-01-01 00:00:02 +0000 UTC`},
+	}})
+}
@@ -0,0 +1,156 @@
+package starlarkassert
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	starlarkjson "go.starlark.net/lib/json"
+	"go.starlark.net/starlark"
+)
+
+var jsonEncode = starlarkjson.Module.Members["encode"].(*starlark.Builtin)
+
+// encodeJSON marshals v to indented JSON.
+func encodeJSON(thread *starlark.Thread, v starlark.Value) ([]byte, error) {
+	s, err := starlark.Call(thread, jsonEncode, starlark.Tuple{v}, nil)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, []byte(s.(starlark.String)), "", "  "); err != nil {
+		return nil, err
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+func decodeJSON(b []byte) (interface{}, error) {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// jsonDiff records a line per difference between decoded JSON values.
+func jsonDiff(lines []string, path string, got, want interface{}) []string {
+	switch w := want.(type) {
+	case map[string]interface{}:
+		g, ok := got.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(w)+len(g))
+		for k := range w {
+			keys = append(keys, k)
+		}
+		for k := range g {
+			if _, ok := w[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			gv, gok := g[k]
+			wv, wok := w[k]
+			switch {
+			case !gok:
+				lines = append(lines, fmt.Sprintf("%s.%s: missing", path, k))
+			case !wok:
+				lines = append(lines, fmt.Sprintf("%s.%s: unexpected", path, k))
+			default:
+				lines = jsonDiff(lines, path+"."+k, gv, wv)
+			}
+		}
+		return lines
+	case []interface{}:
+		g, ok := got.([]interface{})
+		if !ok {
+			break
+		}
+		if len(g) != len(w) {
+			return append(lines, fmt.Sprintf("%s: got %d elements, want %d", path, len(g), len(w)))
+		}
+		for i := range w {
+			lines = jsonDiff(lines, fmt.Sprintf("%s[%d]", path, i), g[i], w[i])
+		}
+		return lines
+	case json.Number:
+		if g, ok := got.(json.Number); ok && numberEqual(g, w) {
+			return lines
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		gb, _ := json.Marshal(got)
+		wb, _ := json.Marshal(want)
+		lines = append(lines, fmt.Sprintf("%s: got %s, want %s", path, gb, wb))
+	}
+	return lines
+}
+
+// numberEqual reports whether the JSON numbers are equal by value, so that 1,
+// 1.0 and 1e0 are equal.
+func numberEqual(x, y json.Number) bool {
+	if x == y {
+		return true
+	}
+	xr, ok := new(big.Rat).SetString(string(x))
+	if !ok {
+		return false
+	}
+	yr, ok := new(big.Rat).SetString(string(y))
+	if !ok {
+		return false
+	}
+	return xr.Cmp(yr) == 0
+}
+
+func tequalJSONFile(t testing.TB, thread *starlark.Thread, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		value starlark.Value
+		path  string
+	)
+	if err := starlark.UnpackArgs("equal_json_file", args, kwargs, "value", &value, "path", &path); err != nil {
+		return nil, err
+	}
+	b, err := encodeJSON(thread, value)
+	if err != nil {
+		return nil, err
+	}
+	if updateGoldens() {
+		if err := os.WriteFile(path, b, 0666); err != nil {
+			return nil, err
+		}
+		return starlark.True, nil
+	}
+
+	wantb, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	want, err := decodeJSON(wantb)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	got, err := decodeJSON(b)
+	if err != nil {
+		return nil, err
+	}
+
+	if lines := jsonDiff(nil, "$", got, want); len(lines) > 0 {
+		msg := fmt.Sprintf("%s differs:\n%s", path, strings.Join(lines, "\n"))
+		thread.Print(thread, msg)
+		t.Fail()
+		return starlark.False, nil
+	}
+	return starlark.True, nil
+}
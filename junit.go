@@ -0,0 +1,164 @@
+package starlarkassert
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.starlark.net/starlark"
+)
+
+// WithJUnitOutput writes the results of each test as a JUnit XML document to
+// w. Each file is a test suite. The document is written once all files started
+// with the option complete, so running files from one Go test, as RunTests
+// does, writes a single document.
+func WithJUnitOutput(w io.Writer) TestOption {
+	j := &junitReport{w: w, suites: make(map[string]*junitSuite)}
+	return func(_ testing.TB, thread *starlark.Thread) func() {
+		addObserver(thread, j)
+		return nil
+	}
+}
+
+type junitSuites struct {
+	XMLName  xml.Name      `xml:"testsuites"`
+	Tests    int           `xml:"tests,attr"`
+	Failures int           `xml:"failures,attr"`
+	Skipped  int           `xml:"skipped,attr"`
+	Time     string        `xml:"time,attr"`
+	Suites   []*junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string       `xml:"name,attr"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Skipped  int          `xml:"skipped,attr"`
+	Time     string       `xml:"time,attr"`
+	Cases    []*junitCase `xml:"testcase"`
+
+	start time.Time
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+func junitTime(d time.Duration) string { return fmt.Sprintf("%.3f", d.Seconds()) }
+
+// junitReport records results by filename until all started files end. Safe
+// for concurrent use by parallel tests.
+type junitReport struct {
+	w       io.Writer
+	mu      sync.Mutex
+	start   time.Time
+	pending int
+	suites  map[string]*junitSuite
+}
+
+func (j *junitReport) startFile(filename string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.pending == 0 {
+		j.start = time.Now()
+	}
+	j.pending++
+	j.suites[filename] = &junitSuite{Name: filename, start: time.Now()}
+}
+
+func (j *junitReport) startTest(t testing.TB, thread *starlark.Thread, name string) func(err error) {
+	start := time.Now()
+
+	var msgs []string
+	print := thread.Print
+	thread.Print = func(thread *starlark.Thread, msg string) {
+		msgs = append(msgs, msg)
+		print(thread, msg)
+	}
+	return func(err error) {
+		thread.Print = print
+		if err != nil {
+			msgs = append(msgs, err.Error())
+		}
+
+		c := &junitCase{
+			Name:      name,
+			Classname: thread.Name,
+			Time:      junitTime(time.Since(start)),
+		}
+		var msg string
+		if len(msgs) > 0 {
+			msg = msgs[len(msgs)-1]
+		}
+		switch {
+		case t.Failed():
+			c.Failure = &junitMessage{Message: msg, Text: strings.Join(msgs, "\n")}
+		case t.Skipped():
+			c.Skipped = &junitMessage{Message: msg}
+		}
+
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		s := j.suites[thread.Name]
+		if s == nil {
+			s = &junitSuite{Name: thread.Name, start: start}
+			j.suites[thread.Name] = s
+		}
+		s.Cases = append(s.Cases, c)
+	}
+}
+
+// endFile writes the document once no started files remain.
+func (j *junitReport) endFile(filename string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if s := j.suites[filename]; s != nil {
+		s.Time = junitTime(time.Since(s.start))
+	}
+	if j.pending--; j.pending > 0 {
+		return
+	}
+
+	doc := junitSuites{Time: junitTime(time.Since(j.start))}
+	for _, s := range j.suites {
+		sort.Slice(s.Cases, func(i, k int) bool { return s.Cases[i].Name < s.Cases[k].Name })
+		s.Tests = len(s.Cases)
+		for _, c := range s.Cases {
+			if c.Failure != nil {
+				s.Failures++
+			}
+			if c.Skipped != nil {
+				s.Skipped++
+			}
+		}
+		doc.Tests += s.Tests
+		doc.Failures += s.Failures
+		doc.Skipped += s.Skipped
+		doc.Suites = append(doc.Suites, s)
+	}
+	sort.Slice(doc.Suites, func(i, k int) bool { return doc.Suites[i].Name < doc.Suites[k].Name })
+	j.suites = make(map[string]*junitSuite)
+
+	io.WriteString(j.w, xml.Header)
+	enc := xml.NewEncoder(j.w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err == nil {
+		io.WriteString(j.w, "\n")
+	}
+}
@@ -0,0 +1,101 @@
+package starlarkassert
+
+import (
+	"encoding/xml"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.starlark.net/starlark"
+)
+
+func TestJUnitOutput(t *testing.T) {
+	var buf strings.Builder
+	opt := WithJUnitOutput(&buf)
+	thread := &starlark.Thread{Name: "junit.star"}
+	opt(t, thread)
+	j := getObservers(thread)[0].(*junitReport)
+
+	run := func(name string, fail, skip bool, msgs ...string) {
+		r := &recorder{TB: t}
+		thread.Print = func(*starlark.Thread, string) {}
+		done := j.startTest(r, thread, name)
+		for _, msg := range msgs {
+			thread.Print(thread, msg)
+		}
+		var err error
+		if fail {
+			r.Fail()
+			err = errors.New("boom")
+		}
+		if skip {
+			r.skipped = true
+		}
+		done(err)
+	}
+	j.startFile("junit.star")
+	run("test_skip", false, true, "not supported")
+	run("test_fail", true, false, "1 != 2")
+	run("test_pass", false, false, "ok")
+	j.endFile("junit.star")
+
+	if !strings.HasPrefix(buf.String(), xml.Header) {
+		t.Errorf("missing xml header:\n%s", buf.String())
+	}
+	var doc junitSuites
+	if err := xml.Unmarshal([]byte(buf.String()), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Tests != 3 || doc.Failures != 1 || doc.Skipped != 1 || doc.Time == "" {
+		t.Errorf("got testsuites %+v", doc)
+	}
+	if len(doc.Suites) != 1 {
+		t.Fatalf("got %d suites, want 1", len(doc.Suites))
+	}
+	s := doc.Suites[0]
+	if s.Name != "junit.star" || s.Tests != 3 || s.Failures != 1 || s.Skipped != 1 || s.Time == "" {
+		t.Errorf("got testsuite %+v", s)
+	}
+
+	type testcase struct {
+		name    string
+		failure *junitMessage
+		skipped *junitMessage
+	}
+	var got []testcase
+	for _, c := range s.Cases {
+		if c.Classname != "junit.star" || c.Time == "" {
+			t.Errorf("got testcase %+v", c)
+		}
+		got = append(got, testcase{c.Name, c.Failure, c.Skipped})
+	}
+	want := []testcase{
+		{"test_fail", &junitMessage{Message: "boom", Text: "1 != 2\nboom"}, nil},
+		{"test_pass", nil, nil},
+		{"test_skip", nil, &junitMessage{Message: "not supported"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestJUnitOutputFiles(t *testing.T) {
+	var buf strings.Builder
+	opt := WithJUnitOutput(&buf)
+	t.Run("files", func(t *testing.T) {
+		TestFile(t, "a.star", `def test_a(t): pass`, nil, opt)
+		TestFile(t, "b.star", `def test_b(t): t.skip()`, nil, opt)
+	})
+
+	if n := strings.Count(buf.String(), "<testsuites"); n != 1 {
+		t.Fatalf("got %d documents, want 1:\n%s", n, buf.String())
+	}
+	var doc junitSuites
+	if err := xml.Unmarshal([]byte(buf.String()), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Tests != 2 || doc.Skipped != 1 || len(doc.Suites) != 2 {
+		t.Errorf("got %+v", doc)
+	}
+}
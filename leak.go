@@ -0,0 +1,40 @@
+package starlarkassert
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"go.starlark.net/starlark"
+)
+
+// WithLeakCheck fails tests that leave more goroutines running than when
+// they started, catching builtins that spawn goroutines without cleaning up.
+// Goroutines are given a short grace period to exit. Counts are process-wide
+// so the check is unreliable with parallel tests and shouldn't be combined
+// with InParallel.
+func WithLeakCheck() TestOption {
+	return func(_ testing.TB, thread *starlark.Thread) func() {
+		addObserver(thread, leakCheck{})
+		return nil
+	}
+}
+
+// leakGracePeriod is how long goroutines are waited on to exit.
+const leakGracePeriod = 100 * time.Millisecond
+
+type leakCheck struct{}
+
+func (leakCheck) startTest(t testing.TB, _ *starlark.Thread, name string) func(err error) {
+	before := runtime.NumGoroutine()
+	return func(error) {
+		after := runtime.NumGoroutine()
+		for deadline := time.Now().Add(leakGracePeriod); after > before && time.Now().Before(deadline); {
+			time.Sleep(time.Millisecond)
+			after = runtime.NumGoroutine()
+		}
+		if after > before {
+			t.Errorf("%s: leaked %d goroutines (%d before, %d after)", name, after-before, before, after)
+		}
+	}
+}
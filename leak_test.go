@@ -0,0 +1,45 @@
+package starlarkassert
+
+import (
+	"strings"
+	"testing"
+
+	"go.starlark.net/starlark"
+)
+
+func TestWithLeakCheck(t *testing.T) {
+	stop := make(chan struct{})
+	defer close(stop)
+	globals := starlark.StringDict{
+		"spawn": starlark.NewBuiltin("spawn", func(_ *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, _ []starlark.Tuple) (starlark.Value, error) {
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				if len(args) > 0 {
+					<-stop // leak
+				}
+			}()
+			if len(args) == 0 {
+				<-done
+			}
+			return starlark.None, nil
+		}),
+	}
+	TestFile(t, "leak.star", "def test_no_leak(t):\n    spawn()\n", globals, WithLeakCheck())
+
+	thread := &starlark.Thread{Name: "leak.star"}
+	WithLeakCheck()(t, thread)
+	o := getObservers(thread)[0]
+	r := &recorder{TB: t}
+	done := o.startTest(r, thread, "test_leak")
+	if _, err := starlark.Call(thread, globals["spawn"], starlark.Tuple{starlark.True}, nil); err != nil {
+		t.Fatal(err)
+	}
+	done(nil)
+	if !r.failed {
+		t.Fatal("expected leak to be reported")
+	}
+	if got, want := r.output(), "test_leak: leaked 1 goroutines"; !strings.HasPrefix(got, want) {
+		t.Errorf("got %q, want prefix %q", got, want)
+	}
+}
@@ -0,0 +1,76 @@
+package starlarkassert
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"go.starlark.net/starlark"
+)
+
+// observersKey is the thread local storing the []testObserver of options.
+const observersKey = "starlarkassert.observers"
+
+// testObserver is notified of each test run by TestFile.
+type testObserver interface {
+	// startTest is called as the test starts, returning a func called with
+	// the test's error, if any, once the test completes.
+	startTest(t testing.TB, thread *starlark.Thread, name string) func(err error)
+}
+
+// fileObserver is a testObserver notified once all tests in a file complete.
+type fileObserver interface {
+	endFile(filename string)
+}
+
+// fileStarter is a fileObserver notified as a file starts.
+type fileStarter interface {
+	startFile(filename string)
+}
+
+func addObserver(thread *starlark.Thread, o testObserver) {
+	observers, _ := thread.Local(observersKey).([]testObserver)
+	setLocal(thread, observersKey, append(observers, o))
+}
+
+func getObservers(thread *starlark.Thread) []testObserver {
+	observers, _ := thread.Local(observersKey).([]testObserver)
+	return observers
+}
+
+// WithResultCallback calls fn as each test completes with the test's name,
+// whether it passed, its duration and the messages it printed. Calls are
+// serialized so fn needn't be safe for concurrent use.
+func WithResultCallback(fn func(name string, passed bool, dur time.Duration, msgs []string)) TestOption {
+	r := &resultCallback{fn: fn}
+	return func(_ testing.TB, thread *starlark.Thread) func() {
+		addObserver(thread, r)
+		return nil
+	}
+}
+
+type resultCallback struct {
+	mu sync.Mutex
+	fn func(name string, passed bool, dur time.Duration, msgs []string)
+}
+
+func (r *resultCallback) startTest(t testing.TB, thread *starlark.Thread, name string) func(err error) {
+	start := time.Now()
+
+	var msgs []string
+	print := thread.Print
+	thread.Print = func(thread *starlark.Thread, msg string) {
+		msgs = append(msgs, msg)
+		print(thread, msg)
+	}
+	return func(err error) {
+		thread.Print = print
+		if err != nil {
+			msgs = append(msgs, err.Error())
+		}
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.fn(t.Name(), !t.Failed(), time.Since(start), msgs)
+	}
+}
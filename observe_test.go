@@ -0,0 +1,52 @@
+package starlarkassert
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.starlark.net/starlark"
+)
+
+func TestWithResultCallback(t *testing.T) {
+	type result struct {
+		name   string
+		passed bool
+		msgs   []string
+	}
+	var results []result
+	opt := WithResultCallback(func(name string, passed bool, dur time.Duration, msgs []string) {
+		if dur <= 0 {
+			t.Errorf("%s: got duration %v", name, dur)
+		}
+		results = append(results, result{name, passed, msgs})
+	})
+
+	src := `
+def test_pass(t):
+    print("passing")
+    t.eq(1, 1)
+`
+	t.Run("file", func(t *testing.T) {
+		TestFile(t, "results.star", src, nil, opt)
+	})
+
+	thread := &starlark.Thread{Name: "results.star"}
+	opt(t, thread)
+	o := getObservers(thread)[0]
+	r := &recorder{TB: t}
+	thread.Print = func(*starlark.Thread, string) {}
+	done := o.startTest(r, thread, "test_fail")
+	thread.Print(thread, "1 != 2")
+	r.Fail()
+	done(errors.New("boom"))
+
+	want := []result{
+		{"TestWithResultCallback/file/test_pass", true, []string{"passing"}},
+		{t.Name(), false, []string{"1 != 2", "boom"}},
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("got %+v, want %+v", results, want)
+	}
+}
@@ -0,0 +1,71 @@
+package starlarkassert
+
+import (
+	"fmt"
+	"testing"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// orderWarningsKey is the thread local set by WithOrderWarnings.
+const orderWarningsKey = "starlarkassert.orderwarnings"
+
+// WithOrderWarnings logs a warning for each assertion in a file comparing the
+// result of keys(), values() or items().
+//
+// Starlark dicts and sets iterate in insertion order, so their order is
+// reproducible if their contents are built in a reproducible order. Values
+// built from Go maps, or by parallel code, aren't ordered, so such comparisons
+// should use sorted or t.sorted_items instead.
+func WithOrderWarnings() TestOption {
+	return func(_ testing.TB, thread *starlark.Thread) func() {
+		setLocal(thread, orderWarningsKey, true)
+		return nil
+	}
+}
+
+// orderedCompares are the assertions that compare the order of sequences.
+var orderedCompares = map[string]bool{
+	"eq": true, "equal": true, "ne": true, "not_equal": true,
+}
+
+// orderWarnings returns a warning for each assertion in src comparing the
+// result of keys(), values() or items().
+func orderWarnings(filename string, src []byte) ([]string, error) {
+	f, err := syntax.Parse(filename, src, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var warnings []string
+	syntax.Walk(f, func(n syntax.Node) bool {
+		call, ok := n.(*syntax.CallExpr)
+		if !ok {
+			return true
+		}
+		if dot, ok := call.Fn.(*syntax.DotExpr); !ok || !orderedCompares[dot.Name.Name] {
+			return true
+		}
+		for _, arg := range call.Args {
+			argCall, ok := arg.(*syntax.CallExpr)
+			if !ok {
+				continue
+			}
+			dot, ok := argCall.Fn.(*syntax.DotExpr)
+			if !ok {
+				continue
+			}
+			switch name := dot.Name.Name; name {
+			case "keys", "values", "items":
+				pos, _ := arg.Span()
+				warnings = append(warnings, fmt.Sprintf(
+					"%s: comparing %s() depends on insertion order, compare sorted(...) or t.sorted_items instead",
+					pos, name,
+				))
+			}
+		}
+		return true
+	})
+	return warnings, nil
+}
@@ -0,0 +1,51 @@
+package starlarkassert
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOrderWarnings(t *testing.T) {
+	src := `
+def test_order(t):
+    d = {"b": 1, "a": 2}
+    t.eq(d.keys(), ["b", "a"])
+    t.eq(sorted(d.keys()), ["a", "b"])
+    t.eq(t.sorted_items(d), [("a", 2), ("b", 1)])
+    t.ne(["a"], d.items())
+`
+	got, err := orderWarnings("order.star", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"order.star:4:10: comparing keys() depends on insertion order, compare sorted(...) or t.sorted_items instead",
+		"order.star:7:17: comparing items() depends on insertion order, compare sorted(...) or t.sorted_items instead",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// The warnings are logged for the file when the option is set.
+	for _, tt := range []struct {
+		opts []TestOption
+		want []string
+	}{
+		{nil, nil},
+		{[]TestOption{WithOrderWarnings()}, want},
+	} {
+		r := &recorder{TB: t}
+		thread, cleanup := newThread(r, "order.star", tt.opts)
+		_, keys, ok := fileTests(r, thread, thread.Name, []byte(src), nil)
+		cleanup()
+		if !ok || !reflect.DeepEqual(keys, []string{"test_order"}) {
+			t.Fatalf("got tests %q, ok %v", keys, ok)
+		}
+		if r.failed {
+			t.Errorf("got failed, want only warnings")
+		}
+		if !reflect.DeepEqual(r.logs, tt.want) {
+			t.Errorf("got logs %q, want %q", r.logs, tt.want)
+		}
+	}
+}
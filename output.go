@@ -0,0 +1,83 @@
+package starlarkassert
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	"go.starlark.net/starlark"
+)
+
+// WithNoDirectOutput fails tests that write to os.Stdout or os.Stderr rather
+// than printing through the thread, which the test log captures. Builtins
+// should print with thread.Print so output is attributed to the test.
+//
+// The files are swapped for the duration of each test function. As they're
+// global to the process, it can't be combined with InParallel.
+func WithNoDirectOutput() TestOption {
+	return func(_ testing.TB, thread *starlark.Thread) func() {
+		addObserver(thread, noDirectOutput{})
+		return nil
+	}
+}
+
+type noDirectOutput struct{}
+
+func (noDirectOutput) startTest(t testing.TB, thread *starlark.Thread, name string) func(err error) {
+	if isParallel(thread) {
+		t.Errorf("%s: WithNoDirectOutput can't be used with parallel tests", thread.Name)
+		return func(error) {}
+	}
+	stdout, err := captureFile(&os.Stdout)
+	if err != nil {
+		t.Errorf("%s: %v", thread.Name, err)
+		return func(error) {}
+	}
+	stderr, err := captureFile(&os.Stderr)
+	if err != nil {
+		stdout()
+		t.Errorf("%s: %v", thread.Name, err)
+		return func(error) {}
+	}
+	return func(error) {
+		if b := stdout(); len(b) > 0 {
+			t.Errorf("%s: %s wrote to stdout directly: %q", thread.Name, name, b)
+		}
+		if b := stderr(); len(b) > 0 {
+			t.Errorf("%s: %s wrote to stderr directly: %q", thread.Name, name, b)
+		}
+	}
+}
+
+// captureFile replaces *f with a pipe until the returned func is called,
+// returning what was written.
+func captureFile(f **os.File) (func() []byte, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	old := *f
+	*f = w
+
+	done := make(chan []byte)
+	go func() {
+		b, _ := io.ReadAll(r)
+		r.Close()
+		done <- b
+	}()
+	return func() []byte {
+		*f = old
+		w.Close()
+		return <-done
+	}, nil
+}
+
+// parallelKey is the thread local set by InParallel.
+const parallelKey = "starlarkassert.parallel"
+
+// isParallel reports whether the test runs in parallel, by InParallel or
+// t.run.
+func isParallel(thread *starlark.Thread) bool {
+	parallel, _ := thread.Local(parallelKey).(bool)
+	return parallel
+}
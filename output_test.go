@@ -0,0 +1,69 @@
+package starlarkassert
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"go.starlark.net/starlark"
+)
+
+func TestWithNoDirectOutput(t *testing.T) {
+	globals := starlark.StringDict{
+		"write": starlark.NewBuiltin("write", func(_ *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, _ []starlark.Tuple) (starlark.Value, error) {
+			fmt.Fprint(os.Stdout, args[0].(starlark.String).GoString())
+			return starlark.None, nil
+		}),
+	}
+	for _, tt := range []struct {
+		src  string
+		want string
+	}{
+		{`print("ok")`, ""},
+		{`write("direct")`, `output.star: test_write wrote to stdout directly: "direct"`},
+	} {
+		r := &recorder{TB: t}
+		stdout := os.Stdout
+		thread, cleanup := newThread(r, "output.star", []TestOption{WithNoDirectOutput()})
+		// Only the test functions are captured, not the file.
+		if os.Stdout != stdout {
+			t.Fatal("stdout replaced for the file")
+		}
+		thread.Print = func(*starlark.Thread, string) {}
+		done := getObservers(thread)[0].startTest(r, thread, "test_write")
+		_, err := starlark.ExecFile(thread, thread.Name, tt.src, globals)
+		done(err)
+		cleanup()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if os.Stdout != stdout {
+			t.Fatal("stdout not restored")
+		}
+		if r.failed != (tt.want != "") {
+			t.Errorf("%s: got failed %v", tt.src, r.failed)
+		}
+		if got := r.output(); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.src, got, tt.want)
+		}
+	}
+
+	// Either order of the options is reported, as is a wrapped InParallel.
+	wrapped := func(t testing.TB, thread *starlark.Thread) func() {
+		return InParallel(t, thread)
+	}
+	want := "parallel.star: WithNoDirectOutput can't be used with parallel tests"
+	for _, opts := range [][]TestOption{
+		{wrapped, WithNoDirectOutput()},
+		{WithNoDirectOutput(), InParallel},
+	} {
+		r := &recorder{TB: t}
+		thread, cleanup := newThread(r, "parallel.star", opts)
+		thread.Print = func(*starlark.Thread, string) {}
+		getObservers(thread)[0].startTest(r, thread, "test_parallel")(nil)
+		cleanup()
+		if r.output() != want {
+			t.Errorf("got %q, want %q", r.output(), want)
+		}
+	}
+}
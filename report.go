@@ -0,0 +1,133 @@
+package starlarkassert
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// AssertReports runs src with "t" bound to the test assertion methods and
+// checks the messages reported match wantMessages, in any order. No messages
+// are wanted of a passing snippet. Assertions stopping the test, like t.fatal,
+// t.skip or those of t.require, stop the snippet. t.run and t.deadline need a
+// *testing.T so report an error. Use it to test custom assertions:
+//
+//	func TestPositive(t *testing.T) {
+//		AssertReports(t, `assert_positive(t, -1)`, globals, []string{
+//			"expected positive, got -1",
+//		})
+//	}
+func AssertReports(t *testing.T, src string, globals starlark.StringDict, wantMessages []string) {
+	t.Helper()
+
+	r := &recorder{TB: t}
+	if err := r.exec("reports.star", src, globals, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(wantMessages) > 0 && !r.failed {
+		t.Errorf("expected failure, got messages:\n%s", r.output())
+		return
+	}
+	if len(wantMessages) == 0 && r.failed {
+		t.Errorf("unexpected failure:\n%s", r.output())
+		return
+	}
+
+	got := append([]string(nil), r.logs...)
+	want := append([]string(nil), wantMessages...)
+	sort.Strings(got)
+	sort.Strings(want)
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got messages:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+// recorder is a testing.TB that records failures rather than reporting them.
+// Other methods are forwarded to the embedded TB.
+type recorder struct {
+	testing.TB
+	failed  bool
+	skipped bool
+	logs    []string
+}
+
+func (r *recorder) Helper()                 {}
+func (r *recorder) Fail()                   { r.failed = true }
+func (r *recorder) FailNow()                { r.failed = true; runtime.Goexit() }
+func (r *recorder) Failed() bool            { return r.failed }
+func (r *recorder) SkipNow()                { r.skipped = true; runtime.Goexit() }
+func (r *recorder) Skipped() bool           { return r.skipped }
+func (r *recorder) Log(args ...interface{}) { r.logs = append(r.logs, fmt.Sprint(args...)) }
+func (r *recorder) Logf(format string, args ...interface{}) {
+	r.logs = append(r.logs, fmt.Sprintf(format, args...))
+}
+func (r *recorder) Error(args ...interface{}) { r.Log(args...); r.Fail() }
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.Logf(format, args...)
+	r.Fail()
+}
+func (r *recorder) Fatal(args ...interface{}) { r.Log(args...); r.FailNow() }
+func (r *recorder) Fatalf(format string, args ...interface{}) {
+	r.Logf(format, args...)
+	r.FailNow()
+}
+func (r *recorder) Skip(args ...interface{}) { r.Log(args...); r.SkipNow() }
+func (r *recorder) Skipf(format string, args ...interface{}) {
+	r.Logf(format, args...)
+	r.SkipNow()
+}
+
+func (r *recorder) output() string { return strings.Join(r.logs, "\n") }
+
+// exec runs src with "t" bound to the test assertion methods, recording
+// failures and printed messages. Like a test, execution stops on FailNow and
+// SkipNow.
+func (r *recorder) exec(filename, src string, globals starlark.StringDict, opts []TestOption) (err error) {
+	members := make(starlark.StringDict)
+	for name, attr := range testAttrs {
+		v := attr(&Test{})
+		if m, ok := v.(tmethod); ok {
+			m.tb = r
+			v = m
+		}
+		members[name] = v
+	}
+	members["require"] = &Require{starlarkstruct.FromStringDict(starlark.String("t"), members)}
+
+	thread := &starlark.Thread{
+		Name:  filename,
+		Print: func(_ *starlark.Thread, msg string) { r.logs = append(r.logs, msg) },
+	}
+	for _, opt := range opts {
+		if cleanup := opt(r, thread); cleanup != nil {
+			defer cleanup()
+		}
+	}
+
+	predeclared := starlark.StringDict{
+		"t": starlarkstruct.FromStringDict(starlark.String("t"), members),
+	}
+	for name, v := range globals {
+		predeclared[name] = v
+	}
+
+	r.run(func() {
+		_, err = starlark.ExecFile(thread, filename, src, predeclared)
+	})
+	return err
+}
+
+// run calls f on a goroutine of its own, as FailNow and SkipNow exit it.
+func (r *recorder) run(f func()) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f()
+	}()
+	<-done
+}
@@ -0,0 +1,54 @@
+package starlarkassert
+
+import (
+	"strings"
+	"testing"
+
+	"go.starlark.net/starlark"
+)
+
+func TestAssertReports(t *testing.T) {
+	globals := starlark.StringDict{
+		"limit": starlark.MakeInt(10),
+	}
+	src := `
+def assert_small(t, x):
+    if x >= limit:
+        t.error("expected less than %d, got %d" % (limit, x))
+`
+	AssertReports(t, src+`assert_small(t, 1)`, globals, nil)
+	AssertReports(t, src+`assert_small(t, 11); t.eq(1, 2)`, globals, []string{
+		`"1" != "2"`,
+		"expected less than 10, got 11",
+	})
+	AssertReports(t, `t.all_match(["a", "b"], "a")`, nil, []string{
+		`element 1 "b" does not match "a"`,
+	})
+	AssertReports(t, `t.fatal("stop"); t.error("unreachable")`, nil, []string{
+		"stop",
+	})
+	AssertReports(t, `t.require.eq(1, 2); t.error("unreachable")`, nil, []string{
+		`"1" != "2"`,
+	})
+}
+
+func TestAssertReportsRun(t *testing.T) {
+	r := &recorder{TB: t}
+	err := r.exec("reports.star", `t.run("sub", lambda t: None)`, nil, nil)
+	if want := "run: no test set on test"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+}
+
+func TestAssertReportsSkip(t *testing.T) {
+	r := &recorder{TB: t}
+	if err := r.exec("reports.star", `t.skip("later"); t.error("unreachable")`, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !r.skipped || r.failed {
+		t.Errorf("got skipped %v, failed %v, want skipped", r.skipped, r.failed)
+	}
+	if got := r.output(); got != "later" {
+		t.Errorf("got %q, want %q", got, "later")
+	}
+}
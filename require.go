@@ -0,0 +1,64 @@
+package starlarkassert
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	"go.starlark.net/starlark"
+)
+
+// Require is the value of t.require, providing the assertions of a test or
+// benchmark that stop it on failure rather than continuing:
+//
+//	def test_foo(t):
+//	    resp = fetch()
+//	    t.require.eq(resp.status, 200)  # stops here on failure
+//	    t.eq(resp.body, "ok")
+type Require struct {
+	recv starlark.HasAttrs
+}
+
+func (r *Require) String() string        { return "<require>" }
+func (r *Require) Type() string          { return "require" }
+func (r *Require) Freeze()               {}
+func (r *Require) Truth() starlark.Bool  { return true }
+func (r *Require) Hash() (uint32, error) { return 0, fmt.Errorf("unhashable type: %s", r.Type()) }
+
+// Attr returns the assertion of the receiver, reporting failures as fatal.
+func (r *Require) Attr(name string) (starlark.Value, error) {
+	v, err := r.recv.Attr(name)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := v.(tmethod)
+	if !ok {
+		return nil, nil
+	}
+	if !isNilTB(m.tb) {
+		m.tb = requireTB{m.tb}
+	}
+	return m, nil
+}
+
+func (r *Require) AttrNames() []string {
+	var names []string
+	for _, name := range r.recv.AttrNames() {
+		if v, _ := r.recv.Attr(name); v != nil {
+			if _, ok := v.(tmethod); ok {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// requireTB stops the test on any failure.
+type requireTB struct {
+	testing.TB
+}
+
+func (r requireTB) Fail()                                     { r.TB.FailNow() }
+func (r requireTB) Error(args ...interface{})                 { r.TB.Fatal(args...) }
+func (r requireTB) Errorf(format string, args ...interface{}) { r.TB.Fatalf(format, args...) }
@@ -0,0 +1,59 @@
+package starlarkassert
+
+import (
+	"reflect"
+	"testing"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+func TestRequire(t *testing.T) {
+	r := &recorder{TB: t}
+	thread := &starlark.Thread{
+		Name:  "require.star",
+		Print: func(_ *starlark.Thread, msg string) { r.logs = append(r.logs, msg) },
+	}
+	members := starlark.StringDict{
+		"eq":      tmethod{starlark.None, "eq", r, teq},
+		"capture": method{starlark.None, "capture", capture},
+	}
+	require := &Require{starlarkstruct.FromStringDict(starlark.String("t"), members)}
+	members["require"] = require
+	test := starlarkstruct.FromStringDict(starlark.String("t"), members)
+
+	var returned bool
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		starlark.ExecFile(thread, thread.Name, `
+t.eq(1, 2)  # continues
+t.require.eq(3, 4)  # stops
+t.eq(5, 6)
+`, starlark.StringDict{"t": test})
+		returned = true
+	}()
+	<-exited
+	if returned {
+		t.Error("execution continued after require.eq")
+	}
+	if !r.failed {
+		t.Error("got passed, want failed")
+	}
+	if got, want := r.output(), "\"1\" != \"2\"\n\"3\" != \"4\""; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if got, want := require.AttrNames(), []string{"eq"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got names %q, want %q", got, want)
+	}
+}
+
+func TestRequirePasses(t *testing.T) {
+	TestFile(t, "require.star", `
+def test_require(t):
+    t.require.eq(1, 1)
+    t.require.true(True)
+    t.require.contains([1, 2], 2)
+`, nil)
+}
@@ -0,0 +1,143 @@
+package starlarkassert
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.starlark.net/starlark"
+)
+
+// WithResolver loads modules from source returned by resolve. The path
+// returned names the module in errors. Each module is executed once, with
+// only the universe predeclared, and its globals are cached for all threads
+// using the option. Cycles in the load graph are reported with the chain of
+// loads. If resolve returns an error wrapping fs.ErrNotExist the previous
+// loader is called.
+//
+//	RunTests(t, "testdata/*.star", globals, WithResolver(func(name string) ([]byte, string, error) {
+//		path := filepath.Join("lib", name)
+//		src, err := os.ReadFile(path)
+//		return src, path, err
+//	}))
+func WithResolver(resolve func(name string) (src []byte, path string, err error)) TestOption {
+	c := &resolverCache{
+		resolve: resolve,
+		entries: make(map[string]*resolverEntry),
+	}
+	return func(_ testing.TB, thread *starlark.Thread) func() {
+		oldLoad := thread.Load
+		thread.Load = func(thread *starlark.Thread, module string) (starlark.StringDict, error) {
+			m, err := c.load(thread, module)
+			if errors.Is(err, fs.ErrNotExist) && oldLoad != nil {
+				return oldLoad(thread, module)
+			}
+			return m, err
+		}
+		return func() { thread.Load = oldLoad }
+	}
+}
+
+// loaderKey is the thread local storing the *resolverLoad of the module a
+// thread executes.
+const loaderKey = "starlarkassert.loader"
+
+// resolverLoad is the load of a module on a thread.
+type resolverLoad struct {
+	chain    []string       // modules loading, outermost first
+	waitsFor *resolverEntry // entry waited on, guarded by resolverCache.mu
+}
+
+type resolverEntry struct {
+	owner   *resolverLoad // load executing the module
+	ready   chan struct{} // closed once globals and err are set
+	globals starlark.StringDict
+	err     error
+}
+
+// resolverCache executes each module once. Safe for concurrent use.
+type resolverCache struct {
+	resolve func(name string) ([]byte, string, error)
+
+	mu      sync.Mutex
+	entries map[string]*resolverEntry
+}
+
+func (c *resolverCache) load(thread *starlark.Thread, module string) (starlark.StringDict, error) {
+	l, _ := thread.Local(loaderKey).(*resolverLoad)
+	if l == nil {
+		l = &resolverLoad{}
+	}
+	for _, name := range l.chain {
+		if name == module {
+			return nil, cycleError(append(l.chain, module))
+		}
+	}
+
+	c.mu.Lock()
+	e := c.entries[module]
+	if e == nil {
+		child := &resolverLoad{chain: append(l.chain[:len(l.chain):len(l.chain)], module)}
+		e = &resolverEntry{owner: child, ready: make(chan struct{})}
+		c.entries[module] = e
+		c.mu.Unlock()
+
+		e.globals, e.err = c.exec(thread, module, child)
+		close(e.ready)
+		return e.globals, e.err
+	}
+
+	select {
+	case <-e.ready:
+		c.mu.Unlock()
+		return e.globals, e.err
+	default:
+	}
+	// Another thread is executing the module. Waiting on it deadlocks if it
+	// waits, directly or not, on this load.
+	for o := e.owner; o != nil; {
+		if o == l {
+			c.mu.Unlock()
+			return nil, cycleError(append(l.chain, module))
+		}
+		if o.waitsFor == nil {
+			break
+		}
+		o = o.waitsFor.owner
+	}
+	l.waitsFor = e
+	c.mu.Unlock()
+
+	<-e.ready
+
+	c.mu.Lock()
+	l.waitsFor = nil
+	c.mu.Unlock()
+	return e.globals, e.err
+}
+
+func (c *resolverCache) exec(thread *starlark.Thread, module string, l *resolverLoad) (starlark.StringDict, error) {
+	src, path, err := c.resolve(module)
+	if err != nil {
+		return nil, err
+	}
+	child := &starlark.Thread{
+		Name:  path,
+		Print: thread.Print,
+		Load:  thread.Load,
+	}
+	child.SetLocal(loaderKey, l)
+	globals, err := starlark.ExecFile(child, path, src, nil)
+	if err != nil {
+		return nil, err
+	}
+	globals.Freeze()
+	return globals, nil
+}
+
+func cycleError(chain []string) error {
+	return fmt.Errorf("cycle in load graph: %s", strings.Join(chain, " -> "))
+}
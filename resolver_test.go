@@ -0,0 +1,122 @@
+package starlarkassert
+
+import (
+	"fmt"
+	"io/fs"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.starlark.net/starlark"
+)
+
+// mapResolver resolves modules from srcs, counting the times each resolved.
+type mapResolver struct {
+	mu    sync.Mutex
+	srcs  map[string]string
+	calls map[string]int
+}
+
+func (r *mapResolver) resolve(name string) ([]byte, string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	src, ok := r.srcs[name]
+	if !ok {
+		return nil, "", fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+	}
+	r.calls[name]++
+	return []byte(src), "lib/" + name, nil
+}
+
+func TestWithResolverDiamond(t *testing.T) {
+	r := &mapResolver{
+		srcs: map[string]string{
+			"b.star": "load(\"d.star\", \"d\")\nb = d + 1\n",
+			"c.star": "load(\"d.star\", \"d\")\nc = d + 2\n",
+			"d.star": "d = 10\n",
+		},
+		calls: make(map[string]int),
+	}
+	src := `
+load("b.star", "b")
+load("c.star", "c")
+load("d.star", "d")
+load("fallback.star", "fallback")
+
+def test_diamond(t):
+    t.eq(b, 11)
+    t.eq(c, 12)
+    t.eq(d, 10)
+    t.eq(fallback, "ok")
+`
+	fallback := WithLoad(func(_ *starlark.Thread, module string) (starlark.StringDict, error) {
+		if module == "fallback.star" {
+			return starlark.StringDict{"fallback": starlark.String("ok")}, nil
+		}
+		return nil, nil
+	})
+	resolver := WithResolver(r.resolve)
+	TestFile(t, "diamond.star", src, nil, fallback, resolver)
+	TestFile(t, "diamond_again.star", src, nil, fallback, resolver)
+
+	for _, name := range []string{"b.star", "c.star", "d.star"} {
+		if n := r.calls[name]; n != 1 {
+			t.Errorf("%s resolved %d times, want 1", name, n)
+		}
+	}
+}
+
+func TestWithResolverCycle(t *testing.T) {
+	r := &mapResolver{
+		srcs: map[string]string{
+			"a.star": "load(\"b.star\", \"b\")\na = 1\n",
+			"b.star": "load(\"c.star\", \"c\")\nb = 1\n",
+			"c.star": "load(\"a.star\", \"a\")\nc = 1\n",
+		},
+		calls: make(map[string]int),
+	}
+	thread := &starlark.Thread{Name: "cycle.star"}
+	WithResolver(r.resolve)(t, thread)
+
+	_, err := thread.Load(thread, "a.star")
+	if err == nil {
+		t.Fatal("expected cycle error")
+	}
+	if want := "cycle in load graph: a.star -> b.star -> c.star -> a.star"; !strings.Contains(err.Error(), want) {
+		t.Errorf("got %v, want %q", err, want)
+	}
+}
+
+func TestWithResolverConcurrentCycle(t *testing.T) {
+	r := &mapResolver{
+		srcs: map[string]string{
+			"a.star": "load(\"b.star\", \"b\")\na = 1\n",
+			"b.star": "load(\"a.star\", \"a\")\nb = 1\n",
+		},
+		calls: make(map[string]int),
+	}
+	// Both modules start executing before either loads the other.
+	var started sync.WaitGroup
+	started.Add(2)
+	resolve := func(name string) ([]byte, string, error) {
+		started.Done()
+		started.Wait()
+		return r.resolve(name)
+	}
+	opt := WithResolver(resolve)
+
+	errs := make(chan error, 2)
+	for _, module := range []string{"a.star", "b.star"} {
+		go func(module string) {
+			thread := &starlark.Thread{Name: module}
+			opt(t, thread)
+			_, err := thread.Load(thread, module)
+			errs <- err
+		}(module)
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err == nil || !strings.Contains(err.Error(), "cycle in load graph") {
+			t.Errorf("got %v, want cycle error", err)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package starlarkassert
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"testing"
+
+	"go.starlark.net/starlark"
+)
+
+// WithFileSummary writes a summary of the failing tests in each file to w,
+// once all of the file's tests complete. Each failing test is listed with its
+// first error.
+func WithFileSummary(w io.Writer) TestOption {
+	s := &fileSummary{w: w, failures: make(map[string][]testFailure)}
+	return func(_ testing.TB, thread *starlark.Thread) func() {
+		addObserver(thread, s)
+		return nil
+	}
+}
+
+type testFailure struct {
+	name string
+	msg  string
+}
+
+// fileSummary records failures by filename. Safe for concurrent use by
+// parallel tests.
+type fileSummary struct {
+	w        io.Writer
+	mu       sync.Mutex
+	failures map[string][]testFailure
+}
+
+// startTest records the test as failing if t has failed when the returned func
+// is called. The message recorded is the last printed before the failure, else
+// the error, else the last printed.
+func (s *fileSummary) startTest(t testing.TB, thread *starlark.Thread, name string) func(err error) {
+	var first, last string
+	print := thread.Print
+	thread.Print = func(thread *starlark.Thread, msg string) {
+		if first == "" && t.Failed() {
+			first = last
+		}
+		last = msg
+		print(thread, msg)
+	}
+	return func(err error) {
+		thread.Print = print
+		if !t.Failed() {
+			return
+		}
+		if first == "" && err != nil {
+			first = err.Error()
+		}
+		if first == "" {
+			first = last
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.failures[thread.Name] = append(s.failures[thread.Name], testFailure{
+			name: name, msg: first,
+		})
+	}
+}
+
+// endFile writes the failures of filename.
+func (s *fileSummary) endFile(filename string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	failures := s.failures[filename]
+	if len(failures) == 0 {
+		return
+	}
+	sort.Slice(failures, func(i, j int) bool { return failures[i].name < failures[j].name })
+
+	fmt.Fprintf(s.w, "FAIL %s: %d failed\n", filename, len(failures))
+	for _, f := range failures {
+		fmt.Fprintf(s.w, "\t%s: %s\n", f.name, f.msg)
+	}
+	delete(s.failures, filename)
+}
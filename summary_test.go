@@ -0,0 +1,42 @@
+package starlarkassert
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"go.starlark.net/starlark"
+)
+
+func TestFileSummary(t *testing.T) {
+	var buf strings.Builder
+	thread := &starlark.Thread{Name: "summary.star"}
+	WithFileSummary(&buf)(t, thread)
+	s := getObservers(thread)[0].(*fileSummary)
+
+	run := func(name string, fail bool, err error, msgs ...string) {
+		r := &recorder{TB: t}
+		thread.Print = func(*starlark.Thread, string) {}
+		done := s.startTest(r, thread, name)
+		for _, msg := range msgs {
+			thread.Print(thread, msg)
+		}
+		if fail {
+			r.Fail()
+			thread.Print(thread, "after")
+		}
+		done(err)
+	}
+	run("test_b", true, nil, "before", "b is wrong")
+	run("test_pass", false, nil, "ok")
+	run("test_a", true, errors.New("a failed"))
+
+	s.endFile("other.star")
+	s.endFile("summary.star")
+	want := "FAIL summary.star: 2 failed\n" +
+		"\ttest_a: a failed\n" +
+		"\ttest_b: b is wrong\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
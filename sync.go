@@ -0,0 +1,88 @@
+package starlarkassert
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// WithSyncModule makes the module "sync.star" loadable by tests, providing
+// values shared safely between parallel tests. counter returns a counter
+// whose inc and get methods are atomic:
+//
+//	load("sync.star", "sync")
+//
+//	running = sync.counter()
+//
+//	def check(t):
+//	    running.inc()
+//	    ...
+//	    running.inc(-1)
+func WithSyncModule() TestOption {
+	module := starlark.StringDict{
+		"sync": &starlarkstruct.Module{
+			Name: "sync",
+			Members: starlark.StringDict{
+				"counter": starlark.NewBuiltin("counter", newCounter),
+			},
+		},
+	}
+	return WithLoad(func(_ *starlark.Thread, name string) (starlark.StringDict, error) {
+		if name == "sync.star" {
+			return module, nil
+		}
+		return nil, nil
+	})
+}
+
+func newCounter(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var n int64
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "n?", &n); err != nil {
+		return nil, err
+	}
+	return &Counter{n: n}, nil
+}
+
+// Counter is an integer safe for concurrent use by starlark threads. It stays
+// mutable when frozen so that it may be shared by the globals of a file.
+type Counter struct {
+	n int64
+}
+
+func (*Counter) Freeze()                 {}
+func (*Counter) Truth() starlark.Bool    { return true }
+func (*Counter) Type() string            { return "counter" }
+func (c *Counter) String() string        { return fmt.Sprintf("counter(%d)", c.Get()) }
+func (*Counter) Hash() (uint32, error)   { return 0, fmt.Errorf("unhashable: counter") }
+func (c *Counter) Get() int64            { return atomic.LoadInt64(&c.n) }
+func (c *Counter) Add(delta int64) int64 { return atomic.AddInt64(&c.n, delta) }
+
+func (c *Counter) Attr(name string) (starlark.Value, error) {
+	switch name {
+	case "inc":
+		return method{c, "inc", c.inc}, nil
+	case "get":
+		return method{c, "get", c.get}, nil
+	}
+	return nil, nil
+}
+
+func (*Counter) AttrNames() []string { return []string{"get", "inc"} }
+
+// inc adds delta, by default one, returning the new value.
+func (c *Counter) inc(_ *starlark.Thread, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	delta := int64(1)
+	if err := starlark.UnpackArgs("inc", args, kwargs, "delta?", &delta); err != nil {
+		return nil, err
+	}
+	return starlark.MakeInt64(c.Add(delta)), nil
+}
+
+func (c *Counter) get(_ *starlark.Thread, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackArgs("get", args, kwargs); err != nil {
+		return nil, err
+	}
+	return starlark.MakeInt64(c.Get()), nil
+}
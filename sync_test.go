@@ -0,0 +1,69 @@
+package starlarkassert
+
+import (
+	"sync"
+	"testing"
+
+	"go.starlark.net/starlark"
+)
+
+func TestCounter(t *testing.T) {
+	c := &Counter{}
+	inc, _ := c.Attr("inc")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			thread := &starlark.Thread{}
+			for i := 0; i < 100; i++ {
+				if _, err := starlark.Call(thread, inc, nil, nil); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if got := c.Get(); got != 800 {
+		t.Errorf("got %d, want 800", got)
+	}
+}
+
+func TestWithSyncModule(t *testing.T) {
+	var calls *Counter
+	globals := starlark.StringDict{
+		"record": starlark.NewBuiltin("record", func(_ *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			calls = args[0].(*Counter)
+			return starlark.None, nil
+		}),
+	}
+	src := `
+load("sync.star", "sync")
+
+calls = sync.counter()
+record(calls)
+
+def test_a(t):
+    calls.inc()
+
+def test_b(t):
+    calls.inc(2)
+
+def test_counter(t):
+    c = sync.counter(5)
+    t.eq(c.inc(), 6)
+    t.eq(c.inc(-2), 4)
+    t.eq(c.get(), 4)
+    t.eq(str(c), "counter(4)")
+`
+	t.Run("group", func(t *testing.T) {
+		t.Run("file", func(t *testing.T) {
+			TestFile(t, "sync.star", src, globals, WithSyncModule(), InParallel)
+		})
+	})
+	if got := calls.Get(); got != 3 {
+		t.Errorf("got %d calls, want 3", got)
+	}
+}
@@ -2,6 +2,8 @@ package starlarkassert
 
 import (
 	"fmt"
+	"io/fs"
+	"math/rand"
 	"path"
 	"path/filepath"
 	"runtime"
@@ -9,8 +11,13 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
+	starlarktime "go.starlark.net/lib/time"
+	"go.starlark.net/resolve"
 	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/syntax"
 )
 
 // Test is passed to starlark testing functions.
@@ -36,22 +43,60 @@ func (t *Test) Hash() (uint32, error) { return 0, fmt.Errorf("unhashable type: %
 type testAttr func(t *Test) starlark.Value
 
 var testAttrs = map[string]testAttr{
-	"error":  func(t *Test) starlark.Value { return tmethod{t, "error", t.t, terror} },
-	"fail":   func(t *Test) starlark.Value { return tmethod{t, "fail", t.t, tfail} },
-	"fatal":  func(t *Test) starlark.Value { return tmethod{t, "fatal", t.t, tfatal} },
-	"freeze": func(t *Test) starlark.Value { return method{t, "freeze", freeze} },
-	"run":    func(t *Test) starlark.Value { return method{t, "run", t.run} },
-	"skip":   func(t *Test) starlark.Value { return tmethod{t, "skip", t.t, tskip} },
-
-	"eq":        func(t *Test) starlark.Value { return tmethod{t, "eq", t.t, teq} },
-	"equal":     func(t *Test) starlark.Value { return tmethod{t, "eq", t.t, teq} },
-	"ne":        func(t *Test) starlark.Value { return tmethod{t, "ne", t.t, tne} },
-	"not_equal": func(t *Test) starlark.Value { return tmethod{t, "ne", t.t, tne} },
-	"true":      func(t *Test) starlark.Value { return tmethod{t, "true", t.t, ttrue} },
-	"lt":        func(t *Test) starlark.Value { return tmethod{t, "lt", t.t, tlt} },
-	"less_than": func(t *Test) starlark.Value { return tmethod{t, "lt", t.t, tlt} },
-	"contains":  func(t *Test) starlark.Value { return tmethod{t, "contains", t.t, tcontains} },
-	"fails":     func(t *Test) starlark.Value { return tmethod{t, "fails", t.t, tfails} },
+	"capture":        func(t *Test) starlark.Value { return method{t, "capture", capture} },
+	"deadline":       func(t *Test) starlark.Value { return method{t, "deadline", t.deadline} },
+	"error":          func(t *Test) starlark.Value { return tmethod{t, "error", t.t, terror} },
+	"fail":           func(t *Test) starlark.Value { return tmethod{t, "fail", t.t, tfail} },
+	"failed":         func(t *Test) starlark.Value { return tmethod{t, "failed", t.t, tfailed} },
+	"fatal":          func(t *Test) starlark.Value { return tmethod{t, "fatal", t.t, tfatal} },
+	"fatalf":         func(t *Test) starlark.Value { return tmethod{t, "fatalf", t.t, tfatalf} },
+	"freeze":         func(t *Test) starlark.Value { return method{t, "freeze", freeze} },
+	"require":        func(t *Test) starlark.Value { return &Require{t} },
+	"log_value":      func(t *Test) starlark.Value { return method{t, "log_value", logValue} },
+	"run":            func(t *Test) starlark.Value { return method{t, "run", t.run} },
+	"skip":           func(t *Test) starlark.Value { return tmethod{t, "skip", t.t, tskip} },
+	"with_timeout":   func(t *Test) starlark.Value { return tmethod{t, "with_timeout", t.t, twithTimeout} },
+	"sorted_items":   func(t *Test) starlark.Value { return method{t, "sorted_items", sortedItems} },
+	"type_of":        func(t *Test) starlark.Value { return method{t, "type_of", typeOf} },
+	"measure_allocs": func(t *Test) starlark.Value { return method{t, "measure_allocs", measureAllocs} },
+
+	"eq":                 func(t *Test) starlark.Value { return tmethod{t, "eq", t.t, teq} },
+	"equal":              func(t *Test) starlark.Value { return tmethod{t, "eq", t.t, teq} },
+	"eq_repr":            func(t *Test) starlark.Value { return tmethod{t, "eq_repr", t.t, teqRepr} },
+	"eq_ignoring":        func(t *Test) starlark.Value { return tmethod{t, "eq_ignoring", t.t, teqIgnoring} },
+	"eq_pairs":           func(t *Test) starlark.Value { return tmethod{t, "eq_pairs", t.t, teqPairs} },
+	"eq_text":            func(t *Test) starlark.Value { return tmethod{t, "eq_text", t.t, teqText} },
+	"eq_iter":            func(t *Test) starlark.Value { return tmethod{t, "eq_iter", t.t, teqIter} },
+	"ne":                 func(t *Test) starlark.Value { return tmethod{t, "ne", t.t, tne} },
+	"not_equal":          func(t *Test) starlark.Value { return tmethod{t, "ne", t.t, tne} },
+	"is_same":            func(t *Test) starlark.Value { return tmethod{t, "is_same", t.t, tisSame} },
+	"true":               func(t *Test) starlark.Value { return tmethod{t, "true", t.t, ttrue} },
+	"is_truthy":          func(t *Test) starlark.Value { return tmethod{t, "is_truthy", t.t, tisTruthy} },
+	"is_falsy":           func(t *Test) starlark.Value { return tmethod{t, "is_falsy", t.t, tisFalsy} },
+	"lt":                 func(t *Test) starlark.Value { return tmethod{t, "lt", t.t, tlt} },
+	"less_than":          func(t *Test) starlark.Value { return tmethod{t, "lt", t.t, tlt} },
+	"within_range":       func(t *Test) starlark.Value { return tmethod{t, "within_range", t.t, twithinRange} },
+	"is_sorted":          func(t *Test) starlark.Value { return tmethod{t, "is_sorted", t.t, tisSorted} },
+	"contains":           func(t *Test) starlark.Value { return tmethod{t, "contains", t.t, tcontains} },
+	"contains_all":       func(t *Test) starlark.Value { return tmethod{t, "contains_all", t.t, tcontainsAll} },
+	"contains_any":       func(t *Test) starlark.Value { return tmethod{t, "contains_any", t.t, tcontainsAny} },
+	"contains_substring": func(t *Test) starlark.Value { return tmethod{t, "contains_substring", t.t, tcontainsSubstring} },
+	"empty":              func(t *Test) starlark.Value { return tmethod{t, "empty", t.t, tempty} },
+	"not_empty":          func(t *Test) starlark.Value { return tmethod{t, "not_empty", t.t, tnotEmpty} },
+	"all_match":          func(t *Test) starlark.Value { return tmethod{t, "all_match", t.t, tallMatch} },
+	"any_match":          func(t *Test) starlark.Value { return tmethod{t, "any_match", t.t, tanyMatch} },
+	"fails":              func(t *Test) starlark.Value { return tmethod{t, "fails", t.t, tfails} },
+	"assert_type_error":  func(t *Test) starlark.Value { return tmethod{t, "assert_type_error", t.t, tassertTypeError} },
+	"panics_with_type":   func(t *Test) starlark.Value { return tmethod{t, "panics_with_type", t.t, tpanicsWithType} },
+
+	"approx":          func(t *Test) starlark.Value { return tmethod{t, "approx", t.t, tapprox} },
+	"approx_eq_list":  func(t *Test) starlark.Value { return tmethod{t, "approx_eq_list", t.t, tapproxEqList} },
+	"duration_approx": func(t *Test) starlark.Value { return tmethod{t, "duration_approx", t.t, tdurationApprox} },
+	"bits_eq":         func(t *Test) starlark.Value { return tmethod{t, "bits_eq", t.t, tbitsEq} },
+	"same_result":     func(t *Test) starlark.Value { return tmethod{t, "same_result", t.t, tsameResult} },
+	"bytes_eq":        func(t *Test) starlark.Value { return tmethod{t, "bytes_eq", t.t, tbytesEq} },
+	"bytes_lt":        func(t *Test) starlark.Value { return tmethod{t, "bytes_lt", t.t, tbytesLt} },
+	"equal_json_file": func(t *Test) starlark.Value { return tmethod{t, "equal_json_file", t.t, tequalJSONFile} },
 }
 
 func (t *Test) Attr(name string) (starlark.Value, error) {
@@ -72,10 +117,23 @@ func (t *Test) AttrNames() []string {
 func wrapLog(t testing.TB, thread *starlark.Thread) func() {
 	_, origFile, origLine, _ := runtime.Caller(0)
 
+	var prefix string
+	if fn, ok := thread.Local(logPrefixKey).(func(string) string); ok {
+		prefix = fn(t.Name())
+	}
+
+	basePrint, _ := thread.Local(basePrintKey).(func(*starlark.Thread, string))
+
 	print := thread.Print
 	thread.Print = func(thread *starlark.Thread, s string) {
+		if basePrint != nil {
+			basePrint(thread, s)
+		}
 		cf := thread.CallFrame(1)
 		s = fmt.Sprintf("%s:%d:%d %s", thread.Name, cf.Pos.Line, cf.Pos.Col, s)
+		if prefix != "" {
+			s = prefix + strings.ReplaceAll(s, "\n", "\n"+prefix)
+		}
 
 		// Overwrite go's filename in log.
 		erase := strings.Repeat("\b", len(path.Base(origFile))+len(strconv.Itoa(origLine))+3)
@@ -87,22 +145,46 @@ func wrapLog(t testing.TB, thread *starlark.Thread) func() {
 	return func() { thread.Print = print }
 }
 
+// checkTest returns an error if t has no *testing.T, as for the t of
+// AssertReports.
+func (t *Test) checkTest(name string) error {
+	if t.t == nil {
+		return fmt.Errorf("%s: no test set on %s; create it with a non-nil testing value", name, t.Type())
+	}
+	return nil
+}
+
 func (t *Test) run(thread *starlark.Thread, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
 	if t.frozen {
 		return nil, fmt.Errorf("testing.t: frozen")
 	}
+	if err := t.checkTest("run"); err != nil {
+		return nil, err
+	}
 
 	var (
-		name string
-		fn   starlark.Callable
+		name     string
+		fn       starlark.Callable
+		parallel bool
 	)
 	if err := starlark.UnpackArgs(
-		"testing.run", args, kwargs, "name", &name, "fn", &fn,
+		"testing.run", args, kwargs, "name", &name, "fn", &fn, "parallel?", &parallel,
 	); err != nil {
 		return nil, err
 	}
 
 	t.t.Run(name, func(t *testing.T) {
+		thread := thread
+		if parallel {
+			// Parallel subtests run concurrently so each needs its own
+			// thread. The options already ran for the parent so their locals
+			// are copied rather than running them again.
+			thread = parallelThread(thread)
+			t.Parallel()
+			if err := runPreExec(thread); err != nil {
+				t.Fatal(err)
+			}
+		}
 		defer wrapLog(t, thread)()
 
 		tval := NewTest(t)
@@ -114,6 +196,38 @@ func (t *Test) run(thread *starlark.Thread, args starlark.Tuple, kwargs []starla
 	return starlark.None, nil
 }
 
+// deadline returns the time remaining until the test binary's deadline, set
+// by the -timeout flag, or None if there is no deadline.
+func (t *Test) deadline(_ *starlark.Thread, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackArgs("deadline", args, kwargs); err != nil {
+		return nil, err
+	}
+	if err := t.checkTest("deadline"); err != nil {
+		return nil, err
+	}
+	d, ok := t.t.Deadline()
+	if !ok {
+		return starlark.None, nil
+	}
+	return starlarktime.Duration(time.Until(d)), nil
+}
+
+// parallelThread returns a thread for a parallel subtest of the test running
+// on thread, with the Load func and option locals of thread. Errors counted by
+// WithMaxErrors are counted for the subtest alone.
+func parallelThread(thread *starlark.Thread) *starlark.Thread {
+	child := &starlark.Thread{
+		Name: thread.Name,
+		Load: thread.Load,
+	}
+	copyLocals(child, thread)
+	setLocal(child, parallelKey, true)
+	if limit, ok := child.Local(maxErrorsKey).(*errorLimit); ok {
+		child.SetLocal(maxErrorsKey, &errorLimit{max: limit.max})
+	}
+	return child
+}
+
 func (t *Test) fatal(thread *starlark.Thread, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
 	s, err := pprint(thread, args, kwargs)
 	if err != nil {
@@ -131,7 +245,7 @@ func errorf(t testing.TB, filename string, err error) {
 		var found bool
 		for i := range err.CallStack {
 			posn := err.CallStack.At(i).Pos
-			if posn.Filename() == filename {
+			if sameFile(posn.Filename(), filename) {
 				linenum := int(posn.Line)
 				msg := err.Error()
 
@@ -143,6 +257,12 @@ func errorf(t testing.TB, filename string, err error) {
 		if !found {
 			t.Error(err.Backtrace())
 		}
+	case syntax.Error:
+		t.Errorf("\n%s: %s", err.Pos, err.Msg)
+	case resolve.ErrorList:
+		for _, err := range err {
+			t.Errorf("\n%s: %s", err.Pos, err.Msg)
+		}
 	case nil:
 		// success
 	default:
@@ -150,6 +270,12 @@ func errorf(t testing.TB, filename string, err error) {
 	}
 }
 
+// sameFile reports whether the paths name the same file, ignoring differences
+// in separators and cleanliness.
+func sameFile(a, b string) bool {
+	return filepath.ToSlash(filepath.Clean(a)) == filepath.ToSlash(filepath.Clean(b))
+}
+
 func newThread(t testing.TB, name string, opts []TestOption) (*starlark.Thread, func()) {
 	thread := &starlark.Thread{Name: name}
 
@@ -167,6 +293,45 @@ func newThread(t testing.TB, name string, opts []TestOption) (*starlark.Thread,
 	}
 }
 
+// localsKey is the thread local storing the keys of the locals set by options,
+// so they can be copied to the threads assertions run on.
+const localsKey = "starlarkassert.locals"
+
+// setLocal sets a thread local of an option.
+func setLocal(thread *starlark.Thread, key string, value interface{}) {
+	keys, _ := thread.Local(localsKey).([]string)
+	if !hasString(keys, key) {
+		thread.SetLocal(localsKey, append(keys[:len(keys):len(keys)], key))
+	}
+	thread.SetLocal(key, value)
+}
+
+// childThread returns a new thread to run starlark called by an assertion on
+// thread, such as the function of t.with_timeout, with its Load and Print
+// funcs and the locals of its options.
+func childThread(thread *starlark.Thread) *starlark.Thread {
+	child := &starlark.Thread{
+		Name:  thread.Name,
+		Print: thread.Print,
+		Load:  thread.Load,
+	}
+	copyLocals(child, thread)
+	return child
+}
+
+// copyLocals copies the locals set by options on src to dst, and the maximum
+// execution steps of WithBaseThread.
+func copyLocals(dst, src *starlark.Thread) {
+	keys, _ := src.Local(localsKey).([]string)
+	for _, key := range keys {
+		dst.SetLocal(key, src.Local(key))
+	}
+	dst.SetLocal(localsKey, keys)
+	if n, ok := src.Local(maxStepsKey).(uint64); ok {
+		dst.SetMaxExecutionSteps(n)
+	}
+}
+
 // TestOption is called on setup with an optional cleanup func called on teardown.
 type TestOption func(t testing.TB, thread *starlark.Thread) func()
 
@@ -189,7 +354,388 @@ func WithLoad(load func(*starlark.Thread, string) (starlark.StringDict, error))
 	}
 }
 
-func InParallel(t testing.TB, _ *starlark.Thread) func() {
+// WithFlags makes values loadable by tests from the module "flags.star",
+// allowing a suite to be parameterised by the go test invocation:
+//
+//	var env = flag.String("env", "dev", "environment to test")
+//
+//	func TestStarlark(t *testing.T) {
+//		RunTests(t, "testdata/*.star", nil, WithFlags(starlark.StringDict{
+//			"env": starlark.String(*env),
+//		}))
+//	}
+//
+// Values are read with get, which returns the default if unset:
+//
+//	load("flags.star", "flags")
+//
+//	env = flags.get("env", "dev")
+func WithFlags(values starlark.StringDict) TestOption {
+	values.Freeze()
+	get := starlark.NewBuiltin("get", func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var (
+			key string
+			def starlark.Value = starlark.None
+		)
+		if err := starlark.UnpackArgs(b.Name(), args, kwargs, "key", &key, "default?", &def); err != nil {
+			return nil, err
+		}
+		if v, ok := values[key]; ok {
+			return v, nil
+		}
+		return def, nil
+	})
+	module := starlark.StringDict{
+		"flags": &starlarkstruct.Module{
+			Name:    "flags",
+			Members: starlark.StringDict{"get": get},
+		},
+	}
+	return WithLoad(func(_ *starlark.Thread, name string) (starlark.StringDict, error) {
+		if name == "flags.star" {
+			return module, nil
+		}
+		return nil, nil
+	})
+}
+
+// WithFailingModule makes loading the module name fail with err, to test code
+// handling a missing optional dependency. Other modules are loaded by the
+// previous loader. A load statement that fails stops the file, so reacting to
+// the error can be asserted with t.fails where modules are loaded by a builtin
+// calling thread.Load:
+//
+//	t.fails(lambda: require("net.star"), "network disabled")
+func WithFailingModule(name string, err error) TestOption {
+	return WithLoad(func(_ *starlark.Thread, module string) (starlark.StringDict, error) {
+		if module == name {
+			return nil, err
+		}
+		return nil, nil
+	})
+}
+
+// preExecKey is the thread local storing the error of a WithPreExec hook.
+const preExecKey = "starlarkassert.preexec"
+
+// preExecHooksKey is the thread local storing the funcs of WithPreExec, to
+// call on the threads of parallel subtests.
+const preExecHooksKey = "starlarkassert.preexechooks"
+
+// WithPreExec calls fn on each new thread before any starlark is executed,
+// allowing thread locals to be registered. An error returned is reported and
+// aborts the file or test.
+func WithPreExec(fn func(thread *starlark.Thread) error) TestOption {
+	return func(_ testing.TB, thread *starlark.Thread) func() {
+		hooks, _ := thread.Local(preExecHooksKey).([]func(*starlark.Thread) error)
+		setLocal(thread, preExecHooksKey, append(hooks[:len(hooks):len(hooks)], fn))
+		if err := fn(thread); err != nil {
+			thread.SetLocal(preExecKey, err)
+		}
+		return nil
+	}
+}
+
+// runPreExec calls the WithPreExec funcs on a thread created without running
+// the options.
+func runPreExec(thread *starlark.Thread) error {
+	hooks, _ := thread.Local(preExecHooksKey).([]func(*starlark.Thread) error)
+	for _, fn := range hooks {
+		if err := fn(thread); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func preExecErr(thread *starlark.Thread) error {
+	err, _ := thread.Local(preExecKey).(error)
+	return err
+}
+
+// noSkipKey is the thread local set by WithNoSkip.
+const noSkipKey = "starlarkassert.noskip"
+
+// WithNoSkip reports calls to skip as failures, ensuring no test is left
+// skipped. The test is stopped as it would be by skip.
+func WithNoSkip() TestOption {
+	return func(_ testing.TB, thread *starlark.Thread) func() {
+		setLocal(thread, noSkipKey, true)
+		return nil
+	}
+}
+
+// WithReflectDiff formats values with uninformative strings, those only
+// naming their type, by the exported fields of their Go value when reporting
+// inequality.
+func WithReflectDiff() TestOption {
+	return func(_ testing.TB, thread *starlark.Thread) func() {
+		setLocal(thread, reflectDiffKey, true)
+		return nil
+	}
+}
+
+// WithDiffContext sets the number of unchanged lines shown around each change
+// when reporting unequal multi-line strings, like diff -U. The default is 3 and
+// negative values are treated as 0.
+func WithDiffContext(lines int) TestOption {
+	return func(_ testing.TB, thread *starlark.Thread) func() {
+		setLocal(thread, diffContextKey, lines)
+		return nil
+	}
+}
+
+// WithMaxErrors stops a test once t.error has reported n errors, to collect a
+// few failures without flooding the log. An n of zero or less is no limit.
+func WithMaxErrors(n int) TestOption {
+	if n <= 0 {
+		return func(testing.TB, *starlark.Thread) func() { return nil }
+	}
+	return func(_ testing.TB, thread *starlark.Thread) func() {
+		setLocal(thread, maxErrorsKey, &errorLimit{max: n})
+		return nil
+	}
+}
+
+// WithRedactor applies fn to every message reported by an assertion, so that
+// secrets in the values compared can be masked before they reach the test log.
+func WithRedactor(fn func(string) string) TestOption {
+	return func(_ testing.TB, thread *starlark.Thread) func() {
+		setLocal(thread, redactorKey, fn)
+		return nil
+	}
+}
+
+// WithSideBySideDiff reports unequal values of differing types, or that can't
+// be diffed, in two columns headed by each type. The elements of lists and
+// tuples are each given a row.
+func WithSideBySideDiff() TestOption {
+	return func(_ testing.TB, thread *starlark.Thread) func() {
+		setLocal(thread, sideBySideKey, true)
+		return nil
+	}
+}
+
+// WithMaxDiffs limits the differences reported between unequal dicts, sets and
+// structs to the first n, noting how many were found.
+func WithMaxDiffs(n int) TestOption {
+	return func(_ testing.TB, thread *starlark.Thread) func() {
+		setLocal(thread, maxDiffsKey, n)
+		return nil
+	}
+}
+
+// hierarchicalKey is the thread local set by WithHierarchicalNames.
+const hierarchicalKey = "starlarkassert.hierarchical"
+
+// WithHierarchicalNames nests the tests of each file in a subtest named by the
+// file's directories and base name, without extension, so that
+// "testdata/dir/file.star" runs "testdata/dir/file/test_foo". Whole
+// directories can then be selected with go test -run.
+func WithHierarchicalNames() TestOption {
+	return func(_ testing.TB, thread *starlark.Thread) func() {
+		setLocal(thread, hierarchicalKey, true)
+		return nil
+	}
+}
+
+// interceptorsKey is the thread local storing the builtins of
+// WithCallInterceptor by name.
+const interceptorsKey = "starlarkassert.interceptors"
+
+// WithCallInterceptor replaces the global builtin name with a stub calling fn
+// for the run, isolating tests from expensive dependencies:
+//
+//	RunTests(t, "testdata/*.star", globals, WithCallInterceptor("fetch",
+//		func(_ *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, _ []starlark.Tuple) (starlark.Value, error) {
+//			return starlark.String("stubbed"), nil
+//		},
+//	))
+func WithCallInterceptor(name string, fn func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error)) TestOption {
+	b := starlark.NewBuiltin(name, fn)
+	return func(_ testing.TB, thread *starlark.Thread) func() {
+		interceptors, _ := thread.Local(interceptorsKey).(starlark.StringDict)
+		m := make(starlark.StringDict, len(interceptors)+1)
+		for k, v := range interceptors {
+			m[k] = v
+		}
+		m[name] = b
+		setLocal(thread, interceptorsKey, m)
+		return nil
+	}
+}
+
+// interceptGlobals returns a copy of globals with the builtins of
+// WithCallInterceptor replaced.
+func interceptGlobals(thread *starlark.Thread, globals starlark.StringDict) starlark.StringDict {
+	interceptors, _ := thread.Local(interceptorsKey).(starlark.StringDict)
+	if len(interceptors) == 0 {
+		return globals
+	}
+	m := make(starlark.StringDict, len(globals)+len(interceptors))
+	for k, v := range globals {
+		m[k] = v
+	}
+	for k, v := range interceptors {
+		m[k] = v
+	}
+	return m
+}
+
+// maxConcurrencyKey is the thread local storing the semaphore of
+// WithMaxConcurrency.
+const maxConcurrencyKey = "starlarkassert.maxconcurrency"
+
+// WithMaxConcurrency limits the number of tests run at once to n, for parallel
+// tests sharing a limited resource such as database connections. The limit is
+// shared by all files run with the option. An n of zero or less is no limit.
+func WithMaxConcurrency(n int) TestOption {
+	if n <= 0 {
+		return func(testing.TB, *starlark.Thread) func() { return nil }
+	}
+	sem := make(chan struct{}, n)
+	return func(_ testing.TB, thread *starlark.Thread) func() {
+		setLocal(thread, maxConcurrencyKey, sem)
+		return nil
+	}
+}
+
+// logPrefixKey is the thread local storing the func of WithLogPrefix.
+const logPrefixKey = "starlarkassert.logprefix"
+
+// WithLogPrefix prefixes each line printed by a test with fn called with the
+// test's name, so the output of parallel tests can be told apart:
+//
+//	WithLogPrefix(func(name string) string {
+//		return "[" + path.Base(name) + "] "
+//	})
+func WithLogPrefix(fn func(name string) string) TestOption {
+	return func(_ testing.TB, thread *starlark.Thread) func() {
+		setLocal(thread, logPrefixKey, fn)
+		return nil
+	}
+}
+
+// WithStackTraces prints the starlark call stack after an assertion fails, to
+// locate failures in shared helper functions.
+func WithStackTraces() TestOption {
+	return func(_ testing.TB, thread *starlark.Thread) func() {
+		setLocal(thread, stackTracesKey, true)
+		return nil
+	}
+}
+
+// WithFloatTolerance makes eq and ne compare floats within the relative or
+// absolute tolerance, including floats nested in lists, tuples, dicts and
+// structs.
+func WithFloatTolerance(rel, abs float64) TestOption {
+	tol := &tolerance{rel: rel, abs: abs}
+	return func(_ testing.TB, thread *starlark.Thread) func() {
+		setLocal(thread, toleranceKey, tol)
+		return nil
+	}
+}
+
+// requireTestsKey is the thread local set by WithRequireTests.
+const requireTestsKey = "starlarkassert.requiretests"
+
+// WithRequireTests fails a file with no test functions, catching files whose
+// tests are misnamed and so silently never run.
+func WithRequireTests() TestOption {
+	return func(_ testing.TB, thread *starlark.Thread) func() {
+		setLocal(thread, requireTestsKey, true)
+		return nil
+	}
+}
+
+func requireTests(t testing.TB, thread *starlark.Thread, filename string, n int) {
+	t.Helper()
+	if require, _ := thread.Local(requireTestsKey).(bool); require && n == 0 {
+		t.Errorf("%s: no test functions found", filename)
+	}
+}
+
+// shuffleKey is the thread local storing the seed of WithShuffle.
+const shuffleKey = "starlarkassert.shuffle"
+
+// WithShuffle randomizes the order tests are run in each file to surface
+// ordering dependencies. The seed is logged for reproduction. Without it tests
+// run in name order.
+func WithShuffle(seed int64) TestOption {
+	return func(_ testing.TB, thread *starlark.Thread) func() {
+		setLocal(thread, shuffleKey, seed)
+		return nil
+	}
+}
+
+// repeatKey is the thread local storing the count of WithRepeat.
+const repeatKey = "starlarkassert.repeat"
+
+// WithRepeat runs each test n times, as subtests named "#1" to "#n", to surface
+// flaky tests. The test fails if any run fails. With InParallel the runs are
+// parallel too.
+func WithRepeat(n int) TestOption {
+	return func(_ testing.TB, thread *starlark.Thread) func() {
+		setLocal(thread, repeatKey, n)
+		return nil
+	}
+}
+
+func shuffle(keys []string, seed int64) {
+	r := rand.New(rand.NewSource(seed))
+	r.Shuffle(len(keys), func(i, j int) { keys[i], keys[j] = keys[j], keys[i] })
+}
+
+// WithEnv sets the environment variables for the run, restoring them on
+// cleanup. The environment is process-wide so WithEnv can't be combined with
+// InParallel.
+func WithEnv(env map[string]string) TestOption {
+	return func(t testing.TB, _ *starlark.Thread) func() {
+		for key, value := range env {
+			t.Setenv(key, value)
+		}
+		return nil
+	}
+}
+
+// basePrintKey is the thread local storing the Print func of WithBaseThread.
+const basePrintKey = "starlarkassert.basePrint"
+
+// maxStepsKey is the thread local storing the maximum execution steps of
+// WithBaseThread.
+const maxStepsKey = "starlarkassert.maxsteps"
+
+// WithBaseThread configures each thread like proto, with the locals and the
+// maximum execution steps given, if positive. The Print func of proto is
+// called with each message in addition to logging it to the test. The Load
+// func of proto is chained like WithLoad. The thread name remains the
+// filename. Each thread gets its own copy of the locals, though the values
+// themselves are shared.
+func WithBaseThread(proto *starlark.Thread, locals map[string]interface{}, maxSteps uint64) TestOption {
+	var load TestOption
+	if proto.Load != nil {
+		load = WithLoad(proto.Load)
+	}
+	return func(t testing.TB, thread *starlark.Thread) func() {
+		if proto.Print != nil {
+			setLocal(thread, basePrintKey, proto.Print)
+		}
+		if maxSteps > 0 {
+			setLocal(thread, maxStepsKey, maxSteps)
+			thread.SetMaxExecutionSteps(maxSteps)
+		}
+		for key, value := range locals {
+			setLocal(thread, key, value)
+		}
+		if load != nil {
+			return load(t, thread)
+		}
+		return nil
+	}
+}
+
+func InParallel(t testing.TB, thread *starlark.Thread) func() {
+	setLocal(thread, parallelKey, true)
 	if t, ok := t.(*testing.T); ok {
 		t.Parallel()
 	}
@@ -205,35 +751,159 @@ func TestFile(t *testing.T, filename string, src interface{}, globals starlark.S
 
 	thread, cleanup := newThread(t, filename, opts)
 	t.Cleanup(cleanup)
+	if err := preExecErr(thread); err != nil {
+		errorf(t, filename, err)
+		return
+	}
+	for _, o := range getObservers(thread) {
+		if o, ok := o.(fileStarter); ok {
+			o.startFile(filename)
+		}
+		if o, ok := o.(fileObserver); ok {
+			t.Cleanup(func() { o.endFile(filename) })
+		}
+	}
 
-	values, err := starlark.ExecFile(thread, filename, src, globals)
+	b, err := readSource(filename, src)
 	if err != nil {
 		errorf(t, filename, err)
 		return
 	}
+	if reason := skipReason(thread, b); reason != "" {
+		skipFile(t, thread, filename, reason)
+		return
+	}
+	values, keys, ok := fileTests(t, thread, filename, b, globals)
+	if !ok {
+		return
+	}
 
-	for key, val := range values {
+	repeat, _ := thread.Local(repeatKey).(int)
+	runTests := func(t *testing.T) {
+		for _, key := range keys {
+			key, val := key, values[key]
+			run := func(key string) func(t *testing.T) {
+				return func(t *testing.T) {
+					tt := NewTest(t)
+					name := thread.Name
+					thread, cleanup := newThread(t, name, opts)
+					defer cleanup()
+					if err := preExecErr(thread); err != nil {
+						errorf(t, name, err)
+						return
+					}
+
+					// Deferred to observe tests stopped by FailNow or SkipNow.
+					var err error
+					for _, o := range getObservers(thread) {
+						done := o.startTest(t, thread, key)
+						defer func() { done(err) }()
+					}
+
+					if sem, ok := thread.Local(maxConcurrencyKey).(chan struct{}); ok {
+						sem <- struct{}{}
+						defer func() { <-sem }()
+					}
+					if _, err = starlark.Call(
+						thread, val, starlark.Tuple{tt}, nil,
+					); err != nil {
+						errorf(t, name, err)
+					}
+				}
+			}
+			if repeat <= 1 {
+				t.Run(key, run(key))
+				continue
+			}
+			t.Run(key, func(t *testing.T) {
+				for i := 1; i <= repeat; i++ {
+					name := fmt.Sprintf("#%d", i)
+					t.Run(name, run(key+"/"+name))
+				}
+			})
+		}
+	}
+	if hierarchical, _ := thread.Local(hierarchicalKey).(bool); hierarchical {
+		// Named by convention rather than nested, as sibling files would
+		// otherwise create duplicate directory subtests. go test -run splits
+		// the name on slashes so directories can still be matched.
+		t.Run(strings.Join(nameSegments(filename), "/"), runTests)
+	} else {
+		runTests(t)
+	}
+}
+
+// skipFile reports the file as skipped by a subtest named by the file, so the
+// skip is shown by go test and reported to the observers.
+func skipFile(t *testing.T, thread *starlark.Thread, filename, reason string) {
+	name := strings.Join(nameSegments(filename), "/")
+	t.Run(name, func(t *testing.T) {
+		err := fmt.Errorf("%s: skipped: %s", filename, reason)
+		for _, o := range getObservers(thread) {
+			done := o.startTest(t, thread, name)
+			defer done(err)
+		}
+		t.Skip(err)
+	})
+}
+
+// fileTests executes the file returning its globals and the names of its test
+// functions, in the order to run them. Errors are reported to t.
+func fileTests(t testing.TB, thread *starlark.Thread, filename string, b []byte, globals starlark.StringDict) (starlark.StringDict, []string, bool) {
+	t.Helper()
+
+	if warn, _ := thread.Local(orderWarningsKey).(bool); warn {
+		warnings, err := orderWarnings(filename, b)
+		if err != nil {
+			errorf(t, filename, err)
+			return nil, nil, false
+		}
+		for _, w := range warnings {
+			t.Log(w)
+		}
+	}
+
+	values, err := starlark.ExecFile(thread, filename, b, interceptGlobals(thread, globals))
+	if err != nil {
+		errorf(t, filename, err)
+		return nil, nil, false
+	}
+	if err := reportUnused(thread, filename, b); err != nil {
+		errorf(t, filename, err)
+	}
+
+	var keys []string
+	for _, key := range values.Keys() {
 		if !strings.HasPrefix(key, "test_") {
 			continue // ignore
 		}
-		if _, ok := val.(starlark.Callable); !ok {
+		if _, ok := values[key].(starlark.Callable); !ok {
 			continue // ignore non callable
 		}
+		keys = append(keys, key)
+	}
+	requireTests(t, thread, filename, len(keys))
+	if seed, ok := thread.Local(shuffleKey).(int64); ok {
+		t.Logf("%s: shuffle seed %d", filename, seed)
+		shuffle(keys, seed)
+	}
+	return values, keys, true
+}
 
-		key, val := key, val
-		t.Run(key, func(t *testing.T) {
-			tt := NewTest(t)
-			name := thread.Name
-			thread, cleanup := newThread(t, name, opts)
-			defer cleanup()
+// nameSegments splits the filename, without its extension, into its
+// directories and base name.
+func nameSegments(filename string) []string {
+	name := filepath.ToSlash(filepath.Clean(filename))
+	name = strings.TrimSuffix(name, path.Ext(name))
 
-			if _, err := starlark.Call(
-				thread, val, starlark.Tuple{tt}, nil,
-			); err != nil {
-				errorf(t, name, err)
-			}
-		})
+	var segments []string
+	for _, seg := range strings.Split(name, "/") {
+		if seg == "" || seg == "." || seg == ".." {
+			continue
+		}
+		segments = append(segments, seg)
 	}
+	return segments
 }
 
 // RunTests is a local test suite runner. Each file in the pattern glob is ran.
@@ -255,3 +925,30 @@ func RunTests(t *testing.T, pattern string, globals starlark.StringDict, opts ..
 		TestFile(t, filename, nil, globals, opts...)
 	}
 }
+
+// RunTestsFS is like RunTests but globs and reads files from fsys, allowing
+// test suites to be embedded. Errors are reported with the path in fsys.
+//
+//	//go:embed testdata/*.star
+//	var testdata embed.FS
+//
+//	func TestStarlark(t *testing.T) {
+//		globals := starlark.StringDict{}
+//		RunTestsFS(t, testdata, "testdata/*.star", globals)
+//	}
+func RunTestsFS(t *testing.T, fsys fs.FS, pattern string, globals starlark.StringDict, opts ...TestOption) {
+	t.Helper()
+
+	files, err := fs.Glob(fsys, pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, filename := range files {
+		src, err := fs.ReadFile(fsys, filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		TestFile(t, filename, src, globals, opts...)
+	}
+}
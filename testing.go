@@ -4,9 +4,27 @@ import (
 	"errors"
 	"io"
 	"reflect"
+	"testing"
 	"time"
 )
 
+// RunTestsMain runs setup once before any tests and the returned teardown
+// once after all tests complete. It returns the exit code of m.Run. Use it
+// from TestMain for global fixtures shared by starlark test suites:
+//
+//	func TestMain(m *testing.M) {
+//		os.Exit(RunTestsMain(m, func() func() {
+//			db := startDatabase()
+//			return func() { db.Close() }
+//		}))
+//	}
+func RunTestsMain(m *testing.M, setup func() func()) int {
+	if teardown := setup(); teardown != nil {
+		defer teardown()
+	}
+	return m.Run()
+}
+
 type corpusEntry = struct {
 	Parent     string
 	Path       string
@@ -21,7 +39,7 @@ type corpusEntry = struct {
 var errMain = errors.New("testing: unexpected use of func Main")
 
 // MatchStringOnly is an implementation of the internal testing.testDeps interface.
-// Interface is unstable and likely to break in new go versions. Current go 1.18.
+// Interface is unstable and likely to break in new go versions.
 type MatchStringOnly func(pat, str string) (bool, error)
 
 func (f MatchStringOnly) MatchString(pat, str string) (bool, error)   { return f(pat, str) }
@@ -29,6 +47,7 @@ func (f MatchStringOnly) StartCPUProfile(w io.Writer) error           { return e
 func (f MatchStringOnly) StopCPUProfile()                             {}
 func (f MatchStringOnly) WriteProfileTo(string, io.Writer, int) error { return errMain }
 func (f MatchStringOnly) ImportPath() string                          { return "" }
+func (f MatchStringOnly) ModulePath() string                          { return "" }
 func (f MatchStringOnly) StartTestLog(io.Writer)                      {}
 func (f MatchStringOnly) StopTestLog() error                          { return errMain }
 func (f MatchStringOnly) SetPanicOnExit0(bool)                        {}
@@ -42,3 +61,6 @@ func (f MatchStringOnly) ReadCorpus(string, []reflect.Type) ([]corpusEntry, erro
 func (f MatchStringOnly) CheckCorpus([]any, []reflect.Type) error { return nil }
 func (f MatchStringOnly) ResetCoverage()                          {}
 func (f MatchStringOnly) SnapshotCoverage()                       {}
+func (f MatchStringOnly) InitRuntimeCoverage() (mode string, tearDown func(string, string) (string, error), snapcov func() float64) {
+	return
+}
@@ -1,12 +1,41 @@
 package starlarkassert
 
 import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
+	"time"
 
+	starlarktime "go.starlark.net/lib/time"
 	"go.starlark.net/starlark"
 	"go.starlark.net/starlarkstruct"
 )
 
+// fixture is set up once for all tests by TestMain.
+var fixture starlark.String
+
+func TestMain(m *testing.M) {
+	os.Exit(RunTestsMain(m, func() func() {
+		fixture = "ready"
+		return func() { fixture = "" }
+	}))
+}
+
+func TestRunTestsMain(t *testing.T) {
+	globals := starlark.StringDict{"fixture": fixture}
+	src := `
+def test_fixture(t):
+    t.eq(fixture, "ready")
+`
+	TestFile(t, "main.star", src, globals)
+}
+
 func TestRunTests(t *testing.T) {
 	globals := starlark.StringDict{
 		"struct": starlark.NewBuiltin("struct", starlarkstruct.Make),
@@ -24,8 +53,627 @@ func TestRunTests(t *testing.T) {
 	RunTests(t, "testdata/*.star", globals, opt)
 }
 
+func TestWithEnv(t *testing.T) {
+	globals := starlark.StringDict{
+		"getenv": starlark.NewBuiltin("getenv", func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var key string
+			if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &key); err != nil {
+				return nil, err
+			}
+			return starlark.String(os.Getenv(key)), nil
+		}),
+	}
+	src := `
+def test_env(t):
+    t.eq(getenv("STARLARKASSERT_ENV"), "hello")
+`
+	TestFile(t, "env.star", src, globals, WithEnv(map[string]string{
+		"STARLARKASSERT_ENV": "hello",
+	}))
+
+	// Parallel subtests don't run the options again, as t.Setenv panics in
+	// a parallel test.
+	src = `
+def test_env_parallel(t):
+    t.run("sub", check_env, parallel = True)
+
+def check_env(t):
+    t.eq(getenv("STARLARKASSERT_ENV"), "hello")
+`
+	TestFile(t, "env_parallel.star", src, globals, WithEnv(map[string]string{
+		"STARLARKASSERT_ENV": "hello",
+	}))
+}
+
+func TestRunTestsFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"star/a.star": {Data: []byte("def test_a(t):\n    t.eq(1, 1)\n")},
+		"star/b.star": {Data: []byte("def test_b(t):\n    t.true(True)\n")},
+		"star/c.txt":  {Data: []byte("not starlark")},
+	}
+	RunTestsFS(t, fsys, "star/*.star", nil)
+}
+
+func TestWithHierarchicalNames(t *testing.T) {
+	fsys := fstest.MapFS{
+		"star/a.star":         {Data: []byte("def test_a(t):\n    pass\n")},
+		"star/dir/b.star":     {Data: []byte("def test_b(t):\n    pass\n")},
+		"star/dir/sub/c.star": {Data: []byte("def test_c(t):\n    pass\n")},
+	}
+	var names []string
+	opt := WithResultCallback(func(name string, _ bool, _ time.Duration, _ []string) {
+		names = append(names, name)
+	})
+	for _, pattern := range []string{"star/*.star", "star/*/*.star", "star/*/*/*.star"} {
+		RunTestsFS(t, fsys, pattern, nil, opt, WithHierarchicalNames())
+	}
+
+	want := []string{
+		t.Name() + "/star/a/test_a",
+		t.Name() + "/star/dir/b/test_b",
+		t.Name() + "/star/dir/sub/c/test_c",
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("got %v, want %v", names, want)
+	}
+}
+
+func TestWithRequireTests(t *testing.T) {
+	TestFile(t, "require.star", "def test_a(t):\n    pass\n", nil, WithRequireTests())
+
+	// A file with a misnamed test and a non callable test_ global.
+	src := []byte(`
+def tset_a(t):
+    pass
+
+test_b = 1
+`)
+	for _, tt := range []struct {
+		opts []TestOption
+		want string
+	}{
+		{nil, ""},
+		{[]TestOption{WithRequireTests()}, "empty.star: no test functions found"},
+	} {
+		r := &recorder{TB: t}
+		thread, cleanup := newThread(r, "empty.star", tt.opts)
+		_, keys, ok := fileTests(r, thread, thread.Name, src, nil)
+		cleanup()
+		if !ok || len(keys) != 0 {
+			t.Fatalf("got tests %q, ok %v", keys, ok)
+		}
+		if r.failed != (tt.want != "") {
+			t.Errorf("got failed %v, want %v", r.failed, tt.want != "")
+		}
+		if got := r.output(); got != tt.want {
+			t.Errorf("got %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestWithCallInterceptor(t *testing.T) {
+	globals := starlark.StringDict{
+		"fetch": starlark.NewBuiltin("fetch", func(_ *starlark.Thread, _ *starlark.Builtin, _ starlark.Tuple, _ []starlark.Tuple) (starlark.Value, error) {
+			t.Error("real fetch called")
+			return starlark.None, nil
+		}),
+		"greeting": starlark.String("hello"),
+	}
+	real := globals["fetch"]
+	var calls []string
+	stub := WithCallInterceptor("fetch", func(_ *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, _ []starlark.Tuple) (starlark.Value, error) {
+		calls = append(calls, args[0].String())
+		return starlark.String("stubbed"), nil
+	})
+	src := `
+def test_fetch(t):
+    t.eq(fetch("a"), "stubbed")
+    t.eq(fetch("b"), "stubbed")
+    t.eq(greeting, "hello")
+`
+	TestFile(t, "intercept.star", src, globals, stub)
+
+	if want := []string{`"a"`, `"b"`}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("got %v, want %v", calls, want)
+	}
+	if globals["fetch"] != real {
+		t.Error("globals modified")
+	}
+}
+
+func TestWithMaxConcurrency(t *testing.T) {
+	var (
+		mu            sync.Mutex
+		running, peak int
+	)
+	globals := starlark.StringDict{
+		"work": starlark.NewBuiltin("work", func(_ *starlark.Thread, _ *starlark.Builtin, _ starlark.Tuple, _ []starlark.Tuple) (starlark.Value, error) {
+			mu.Lock()
+			running++
+			if running > peak {
+				peak = running
+			}
+			mu.Unlock()
+
+			time.Sleep(5 * time.Millisecond)
+
+			mu.Lock()
+			running--
+			mu.Unlock()
+			return starlark.None, nil
+		}),
+	}
+	var src strings.Builder
+	for i := 0; i < 10; i++ {
+		fmt.Fprintf(&src, "def test_%d(t):\n    work()\n", i)
+	}
+	// InParallel pauses the file until its parent returns.
+	t.Run("group", func(t *testing.T) {
+		t.Run("file", func(t *testing.T) {
+			TestFile(t, "concurrency.star", src.String(), globals, InParallel, WithMaxConcurrency(2))
+		})
+	})
+
+	if peak == 0 || peak > 2 {
+		t.Errorf("got peak concurrency %d, want at most 2", peak)
+	}
+
+	// No limit rather than blocking on an unbuffered channel.
+	peak = 0
+	t.Run("unlimited", func(t *testing.T) {
+		t.Run("file", func(t *testing.T) {
+			TestFile(t, "concurrency.star", src.String(), globals, InParallel, WithMaxConcurrency(0))
+		})
+	})
+	if peak == 0 {
+		t.Error("no tests ran")
+	}
+}
+
+func TestWithLogPrefix(t *testing.T) {
+	r := &recorder{TB: t}
+	thread := &starlark.Thread{Name: "prefix.star"}
+	WithLogPrefix(func(name string) string { return "[" + name + "] " })(r, thread)
+	defer wrapLog(r, thread)()
+
+	if _, err := starlark.ExecFile(thread, thread.Name, `print("a\nb")`, nil); err != nil {
+		t.Fatal(err)
+	}
+	prefix := "[" + t.Name() + "] "
+	got := strings.TrimLeft(r.output(), "\b")
+	if want := prefix + "prefix.star:1:6 a\n" + prefix + "b"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestWithPreExec(t *testing.T) {
+	globals := starlark.StringDict{
+		"local": starlark.NewBuiltin("local", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var key string
+			if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &key); err != nil {
+				return nil, err
+			}
+			return thread.Local(key).(starlark.Value), nil
+		}),
+	}
+	src := `
+greeting = local("greeting")
+
+def test_pre_exec(t):
+    t.eq(greeting, "hello")
+    t.eq(local("greeting"), "hello")
+`
+	TestFile(t, "pre_exec.star", src, globals, WithPreExec(func(thread *starlark.Thread) error {
+		thread.SetLocal("greeting", starlark.String("hello"))
+		return nil
+	}))
+}
+
+func TestRunParallel(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		seen []string
+	)
+	globals := starlark.StringDict{
+		"record": starlark.NewBuiltin("record", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var name string
+			if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &name); err != nil {
+				return nil, err
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			seen = append(seen, name+":"+thread.Local("greeting").(starlark.String).GoString())
+			return starlark.None, nil
+		}),
+	}
+	src := `
+def check(name):
+    def fn(t):
+        for sub in ["x", "y"]:
+            t.run(sub, check_sub(name + "/" + sub), parallel = True)
+    return fn
+
+def check_sub(name):
+    return lambda t: record(name)
+
+def test_parallel(t):
+    for name in ["a", "b", "c"]:
+        t.run(name, check(name), parallel = True)
+`
+	// InParallel pauses the file until its parent returns.
+	t.Run("group", func(t *testing.T) {
+		t.Run("file", func(t *testing.T) {
+			TestFile(t, "parallel.star", src, globals, InParallel, WithPreExec(func(thread *starlark.Thread) error {
+				thread.SetLocal("greeting", starlark.String("hello"))
+				return nil
+			}))
+		})
+	})
+
+	sort.Strings(seen)
+	want := []string{
+		"a/x:hello", "a/y:hello",
+		"b/x:hello", "b/y:hello",
+		"c/x:hello", "c/y:hello",
+	}
+	if !reflect.DeepEqual(seen, want) {
+		t.Errorf("got %v, want %v", seen, want)
+	}
+}
+
+func TestWithFlags(t *testing.T) {
+	src := `
+load("flags.star", "flags")
+
+size = flags.get("size", 1)
+
+def test_flags(t):
+    t.eq(size, 3)
+    t.eq(len(["x"] * size), 3)
+    t.eq(flags.get("missing"), None)
+    t.eq(flags.get("missing", "default"), "default")
+`
+	TestFile(t, "flags.star", src, nil, WithFlags(starlark.StringDict{
+		"size": starlark.MakeInt(3),
+	}))
+}
+
+func TestDeadline(t *testing.T) {
+	if _, ok := t.Deadline(); !ok {
+		t.Skip("no deadline set by -timeout")
+	}
+	src := `
+def test_deadline(t):
+    d = t.deadline()
+    t.eq(type(d), "time.duration")
+    t.lt(time.parse_duration("0s"), d)
+`
+	TestFile(t, "deadline.star", src, starlark.StringDict{
+		"time": starlarktime.Module,
+	})
+}
+
+func TestWithFailingModule(t *testing.T) {
+	failing := WithFailingModule("net.star", errors.New("network disabled"))
+	globals := starlark.StringDict{
+		"require": starlark.NewBuiltin("require", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var module string
+			if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &module); err != nil {
+				return nil, err
+			}
+			m, err := thread.Load(thread, module)
+			if err != nil {
+				return nil, err
+			}
+			return starlarkstruct.FromStringDict(starlark.String(module), m), nil
+		}),
+	}
+	src := `
+load("flags.star", "flags")
+
+def fetch():
+    return require("net.star").fetch()
+
+def test_optional(t):
+    t.fails(fetch, "network disabled")
+    t.true(require("flags.star").flags)
+`
+	TestFile(t, "failing.star", src, globals, WithFlags(nil), failing)
+
+	r := &recorder{TB: t}
+	err := r.exec("failing.star", `load("net.star", "fetch")`, nil, []TestOption{failing})
+	if err == nil || !strings.Contains(err.Error(), "cannot load net.star: network disabled") {
+		t.Errorf("got %v, want load error", err)
+	}
+}
+
+func TestWithShuffle(t *testing.T) {
+	var order []string
+	globals := starlark.StringDict{
+		"record": starlark.NewBuiltin("record", func(_ *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			order = append(order, string(args[0].(starlark.String)))
+			return starlark.None, nil
+		}),
+	}
+	src := `
+def test_a(t):
+    record("a")
+def test_b(t):
+    record("b")
+def test_c(t):
+    record("c")
+def test_d(t):
+    record("d")
+def test_e(t):
+    record("e")
+`
+	run := func(opts ...TestOption) []string {
+		order = nil
+		t.Run("shuffle", func(t *testing.T) {
+			TestFile(t, "shuffle.star", src, globals, opts...)
+		})
+		return order
+	}
+
+	if got, want := run(), []string{"a", "b", "c", "d", "e"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	first := run(WithShuffle(1))
+	if got := run(WithShuffle(1)); !reflect.DeepEqual(got, first) {
+		t.Errorf("got %v, want %v", got, first)
+	}
+	if want := []string{"c", "a", "b", "e", "d"}; !reflect.DeepEqual(first, want) {
+		t.Errorf("got %v, want %v", first, want)
+	}
+}
+
+func TestErrorfSyntax(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []string
+	}{{
+		name: "parse",
+		src:  "def test_foo(t)\n    pass\n",
+		want: []string{"\nsyntax.star:2:1: got newline, want ':'"},
+	}, {
+		name: "resolve",
+		src:  "def test_foo(t):\n    foo()\n    bar()\n",
+		want: []string{
+			"\nsyntax.star:2:5: undefined: foo",
+			"\nsyntax.star:3:5: undefined: bar",
+		},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &recorder{TB: t}
+			thread := &starlark.Thread{Name: "syntax.star"}
+			_, err := starlark.ExecFile(thread, thread.Name, tt.src, nil)
+			errorf(r, thread.Name, err)
+			if !r.failed {
+				t.Fatal("expected failure")
+			}
+			if got, want := r.output(), strings.Join(tt.want, "\n"); got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestErrorfFilename(t *testing.T) {
+	for _, tt := range []struct {
+		exec, report string
+	}{
+		{"./testdata//errorf.star", "testdata/errorf.star"},
+		{"testdata/errorf.star", "testdata/./errorf.star"},
+		{`testdata\errorf.star`, `testdata\errorf.star`},
+	} {
+		r := &recorder{TB: t}
+		thread := &starlark.Thread{Name: tt.exec}
+		_, err := starlark.ExecFile(thread, tt.exec, "x = 1\ny = x // 0\n", nil)
+		errorf(r, tt.report, err)
+
+		want := fmt.Sprintf("\n%s:2: unexpected error: %s", tt.report, err)
+		if got := r.output(); got != want {
+			t.Errorf("%s: got %q, want %q", tt.exec, got, want)
+		}
+	}
+}
+
 func Test_depsInterface(t *testing.T) {
 	t.Skip() // Just check it compiles
 	var deps MatchStringOnly = nil
 	testing.MainStart(deps, nil, nil, nil, nil)
 }
+
+func TestWithBaseThread(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		prints []string
+	)
+	proto := &starlark.Thread{
+		Print: func(_ *starlark.Thread, msg string) {
+			mu.Lock()
+			prints = append(prints, msg)
+			mu.Unlock()
+		},
+		Load: func(_ *starlark.Thread, module string) (starlark.StringDict, error) {
+			return starlark.StringDict{"name": starlark.String(module)}, nil
+		},
+	}
+	locals := map[string]interface{}{"greeting": "hello"}
+
+	globals := starlark.StringDict{
+		"local": starlark.NewBuiltin("local", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var key string
+			if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &key); err != nil {
+				return nil, err
+			}
+			v, _ := thread.Local(key).(string)
+			return starlark.String(v), nil
+		}),
+		"set_local": starlark.NewBuiltin("set_local", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var key, value string
+			if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 2, &key, &value); err != nil {
+				return nil, err
+			}
+			thread.SetLocal(key, value)
+			return starlark.None, nil
+		}),
+	}
+	src := `
+load("mod.star", "name")
+
+def test_a(t):
+    t.eq(name, "mod.star")
+    t.eq(local("greeting"), "hello")
+    t.eq(local("set"), "")
+    set_local("greeting", "bye")
+    set_local("set", "a")
+    print("a")
+
+def test_b(t):
+    t.eq(local("greeting"), "hello")
+    t.eq(local("set"), "")
+    set_local("set", "b")
+    print("b")
+`
+	t.Run("group", func(t *testing.T) {
+		t.Run("file", func(t *testing.T) {
+			TestFile(t, "base.star", src, globals, WithBaseThread(proto, locals, 1000), InParallel)
+		})
+	})
+	sort.Strings(prints)
+	if want := []string{"a", "b"}; !reflect.DeepEqual(prints, want) {
+		t.Errorf("got prints %q, want %q", prints, want)
+	}
+	if v, ok := locals["set"]; ok {
+		t.Errorf("got base local %v, want unset", v)
+	}
+
+	// The step limit applies to the threads of parallel subtests too.
+	thread := &starlark.Thread{Name: "steps.star"}
+	WithBaseThread(proto, nil, 1000)(t, thread)
+	for _, th := range []*starlark.Thread{thread, parallelThread(thread)} {
+		_, err := starlark.ExecFile(th, th.Name, "def f():\n    for _ in range(10000): pass\nf()\n", nil)
+		if err == nil || !strings.Contains(err.Error(), "too many steps") {
+			t.Errorf("got error %v, want too many steps", err)
+		}
+	}
+
+	// Loads proto doesn't handle fall through to earlier loaders.
+	proto = &starlark.Thread{
+		Load: func(_ *starlark.Thread, module string) (starlark.StringDict, error) {
+			if module == "base.star" {
+				return starlark.StringDict{"name": starlark.String("base")}, nil
+			}
+			return nil, nil
+		},
+	}
+	src = `
+load("base.star", base = "name")
+load("flags.star", "flags")
+
+def test_chained(t):
+    t.eq(base, "base")
+    t.eq(flags.get("size"), 3)
+`
+	TestFile(t, "chained.star", src, nil, WithFlags(starlark.StringDict{
+		"size": starlark.MakeInt(3),
+	}), WithBaseThread(proto, nil, 0))
+}
+
+func TestWithRepeat(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		calls   int
+		results []string
+	)
+	globals := starlark.StringDict{
+		"next": starlark.NewBuiltin("next", func(_ *starlark.Thread, _ *starlark.Builtin, _ starlark.Tuple, _ []starlark.Tuple) (starlark.Value, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			calls++
+			return starlark.MakeInt(calls), nil
+		}),
+	}
+	src := `
+def test_flaky(t):
+    if next() == 3:
+        t.skip("flaked")
+`
+	callback := WithResultCallback(func(name string, passed bool, _ time.Duration, msgs []string) {
+		results = append(results, fmt.Sprintf("%s %v %q", name, passed, msgs))
+	})
+	var junit strings.Builder
+	t.Run("file", func(t *testing.T) {
+		TestFile(t, "repeat.star", src, globals, WithRepeat(5), callback, WithJUnitOutput(&junit))
+	})
+	if calls != 5 {
+		t.Errorf("got %d runs, want 5", calls)
+	}
+
+	// Each run is a subtest of its own.
+	prefix := t.Name() + "/file/test_flaky/"
+	var want []string
+	for i := 1; i <= 5; i++ {
+		want = append(want, fmt.Sprintf("%s#%d true []", prefix, i))
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("got results %q, want %q", results, want)
+	}
+	for _, want := range []string{
+		`<testcase name="test_flaky/#1"`,
+		`<testcase name="test_flaky/#5"`,
+		`<testcase name="test_flaky/#3"`,
+		`<testsuite name="repeat.star" tests="5" failures="0" skipped="1"`,
+	} {
+		if !strings.Contains(junit.String(), want) {
+			t.Errorf("got JUnit:\n%s\nwant %s", junit.String(), want)
+		}
+	}
+
+	// A failing run is reported under its own name.
+	var buf strings.Builder
+	thread := &starlark.Thread{Name: "repeat.star", Print: func(*starlark.Thread, string) {}}
+	WithFileSummary(&buf)(t, thread)
+	o := getObservers(thread)[0]
+	for i := 1; i <= 3; i++ {
+		r := &recorder{TB: t}
+		done := o.startTest(r, thread, fmt.Sprintf("test_flaky/#%d", i))
+		if i == 2 {
+			r.Fail()
+			thread.Print(thread, "flaked")
+		}
+		done(nil)
+	}
+	o.(fileObserver).endFile(thread.Name)
+	if got, want := buf.String(), "FAIL repeat.star: 1 failed\n\ttest_flaky/#2: flaked\n"; got != want {
+		t.Errorf("got summary %q, want %q", got, want)
+	}
+}
+
+func TestAssertionPosition(t *testing.T) {
+	r := &recorder{TB: t}
+	thread := &starlark.Thread{Name: "position.star"}
+	defer wrapLog(r, thread)()
+
+	globals := starlark.StringDict{
+		"t": starlarkstruct.FromStringDict(starlark.String("t"), starlark.StringDict{
+			"eq": tmethod{starlark.None, "eq", r, teq},
+		}),
+	}
+	src := `
+def check():
+    t.eq(1, 2)
+
+check()
+`
+	if _, err := starlark.ExecFile(thread, thread.Name, src, globals); err != nil {
+		t.Fatal(err)
+	}
+	got := strings.TrimLeft(r.output(), "\b")
+	if want := `position.star:3:9 "1" != "2"`; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
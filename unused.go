@@ -0,0 +1,142 @@
+package starlarkassert
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// unusedGlobalsKey is the thread local storing the *unusedReport of
+// WithReportUnusedGlobals.
+const unusedGlobalsKey = "starlarkassert.unusedglobals"
+
+// WithReportUnusedGlobals writes the globals of each file that are never
+// referenced to w, one per line, to find dead helpers. Functions with the
+// prefix "test_" or "bench_" are run rather than referenced so are never
+// reported, nor are names starting with an underscore.
+func WithReportUnusedGlobals(w io.Writer) TestOption {
+	r := &unusedReport{w: w}
+	return func(_ testing.TB, thread *starlark.Thread) func() {
+		setLocal(thread, unusedGlobalsKey, r)
+		return nil
+	}
+}
+
+type unusedReport struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// reportUnused writes the unused globals of src, if the thread has the option.
+func reportUnused(thread *starlark.Thread, filename string, src []byte) error {
+	r, ok := thread.Local(unusedGlobalsKey).(*unusedReport)
+	if !ok {
+		return nil
+	}
+	unused, err := unusedGlobals(filename, src)
+	if err != nil || len(unused) == 0 {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, err = io.WriteString(r.w, strings.Join(unused, "\n")+"\n")
+	return err
+}
+
+// unusedGlobals returns the position and name of each global or load defined
+// in src but not referenced. Assigning to a name, including by augmented
+// assignment, isn't a reference.
+func unusedGlobals(filename string, src []byte) ([]string, error) {
+	f, err := syntax.Parse(filename, src, 0)
+	if err != nil {
+		return nil, err
+	}
+	// Names the file doesn't define are predeclared by the embedder.
+	isPredeclared := func(string) bool { return true }
+	if err := resolve.File(f, isPredeclared, starlark.Universe.Has); err != nil {
+		return nil, err
+	}
+
+	// The globals, and loads which are local to the file, by the identifier
+	// first binding them. Uses in functions of loads are bound to a free
+	// variable of the same first identifier.
+	defs := make(map[*syntax.Ident]bool)
+	for _, b := range f.Module.(*resolve.Module).Globals {
+		defs[b.First] = true
+	}
+	// Identifiers binding a name rather than referencing it.
+	binding := make(map[*syntax.Ident]bool)
+	var assigned func(e syntax.Expr)
+	assigned = func(e syntax.Expr) {
+		switch e := e.(type) {
+		case *syntax.Ident:
+			binding[e] = true
+		case *syntax.TupleExpr:
+			for _, x := range e.List {
+				assigned(x)
+			}
+		case *syntax.ListExpr:
+			for _, x := range e.List {
+				assigned(x)
+			}
+		case *syntax.ParenExpr:
+			assigned(e.X)
+		}
+	}
+	syntax.Walk(f, func(n syntax.Node) bool {
+		switch n := n.(type) {
+		case *syntax.DefStmt:
+			binding[n.Name] = true
+		case *syntax.AssignStmt:
+			assigned(n.LHS)
+		case *syntax.ForStmt:
+			assigned(n.Vars)
+		case *syntax.ForClause:
+			assigned(n.Vars)
+		case *syntax.LoadStmt:
+			for _, id := range n.To {
+				binding[id] = true
+				defs[id.Binding.(*resolve.Binding).First] = true
+			}
+		}
+		return true
+	})
+
+	used := make(map[*syntax.Ident]bool)
+	syntax.Walk(f, func(n syntax.Node) bool {
+		if id, ok := n.(*syntax.Ident); ok && !binding[id] {
+			if b, ok := id.Binding.(*resolve.Binding); ok && b.First != nil {
+				used[b.First] = true
+			}
+		}
+		return true
+	})
+
+	var unused []*syntax.Ident
+	for id := range defs {
+		name := id.Name
+		if used[id] || strings.HasPrefix(name, "_") ||
+			strings.HasPrefix(name, "test_") || strings.HasPrefix(name, "bench_") {
+			continue
+		}
+		unused = append(unused, id)
+	}
+	sort.Slice(unused, func(i, j int) bool {
+		pi, pj := unused[i].NamePos, unused[j].NamePos
+		return pi.Line < pj.Line || (pi.Line == pj.Line && pi.Col < pj.Col)
+	})
+
+	lines := make([]string, len(unused))
+	for i, id := range unused {
+		lines[i] = fmt.Sprintf("%s: %s is unused", id.NamePos, id.Name)
+	}
+	return lines, nil
+}
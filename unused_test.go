@@ -0,0 +1,80 @@
+package starlarkassert
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"go.starlark.net/resolve"
+)
+
+func TestWithReportUnusedGlobals(t *testing.T) {
+	src := `
+load("lib.star", "used_load", "unused_load")
+
+LIMIT = 10
+UNUSED = 1
+_private = 2
+a, b = 1, 2
+
+def helper(x):
+    return x + LIMIT
+
+def unused_helper():
+    pass
+
+def test_helper(t):
+    t.eq(helper(1), 11)
+    t.eq(used_load, a)
+`
+	got, err := unusedGlobals("unused.star", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "unused.star:2:32: unused_load is unused\n" +
+		"unused.star:5:1: UNUSED is unused\n" +
+		"unused.star:7:4: b is unused\n" +
+		"unused.star:12:5: unused_helper is unused"
+	if s := strings.Join(got, "\n"); s != want {
+		t.Errorf("got:\n%s\nwant:\n%s", s, want)
+	}
+
+	// Parameters and locals shadowing a global don't use it.
+	got, err = unusedGlobals("shadow.star", []byte(`
+LIMIT = 10
+count = 0
+
+def helper(LIMIT):
+    count = LIMIT
+    return count
+
+def test_helper(t):
+    t.eq(helper(1), 1)
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = "shadow.star:2:1: LIMIT is unused\n" +
+		"shadow.star:3:1: count is unused"
+	if s := strings.Join(got, "\n"); s != want {
+		t.Errorf("got:\n%s\nwant:\n%s", s, want)
+	}
+
+	// Augmented assignment, where top-level reassignment is allowed, doesn't
+	// use the global.
+	defer func(allow bool) { resolve.AllowGlobalReassign = allow }(resolve.AllowGlobalReassign)
+	resolve.AllowGlobalReassign = true
+	got, err = unusedGlobals("augmented.star", []byte("total = 0\ntotal += 1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "augmented.star:1:1: total is unused"; strings.Join(got, "\n") != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	var buf bytes.Buffer
+	TestFile(t, "unused.star", "def helper():\n    pass\n\ndef test_a(t):\n    pass\n", nil, WithReportUnusedGlobals(&buf))
+	if want := "unused.star:1:5: helper is unused\n"; buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}